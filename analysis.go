@@ -0,0 +1,496 @@
+package rdparser
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EndOfInput is the lookahead that follows the start symbol.
+const EndOfInput = "$"
+
+// Conflict is a choice point the grammar cannot resolve with one token of
+// lookahead, i.e. two branches that may start with the same token.
+type Conflict struct {
+	Rule   NonTerminal
+	Pos    Position
+	Msg    string
+	Tokens []string
+}
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s at %s: %s on %s", c.Rule, c.Pos, c.Msg, strings.Join(c.Tokens, " "))
+}
+
+// Analysis holds the LL(1) properties of an EBNF grammar. Lookahead sets
+// contain quoted literals, <class> names and EndOfInput.
+type Analysis struct {
+	Grammar *EBNF
+
+	Nullable map[NonTerminal]bool
+	First    map[NonTerminal][]string
+	Follow   map[NonTerminal][]string
+
+	Conflicts     []Conflict
+	Unreachable   []NonTerminal
+	Unproductive  []NonTerminal
+	LeftRecursion [][]NonTerminal
+}
+
+type lookahead map[string]bool
+
+func (s lookahead) addAll(o lookahead) bool {
+	changed := false
+	for k := range o {
+		if !s[k] {
+			s[k] = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (s lookahead) union(o lookahead) lookahead {
+	u := lookahead{}
+	u.addAll(s)
+	u.addAll(o)
+	return u
+}
+
+func (s lookahead) sorted() []string {
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s lookahead) intersect(o lookahead) []string {
+	keys := []string{}
+	for k := range s {
+		if o[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type analyzer struct {
+	g *EBNF
+
+	nullable map[NonTerminal]bool
+	first    map[NonTerminal]lookahead
+	follow   map[NonTerminal]lookahead
+}
+
+func Analyze(g *EBNF) *Analysis {
+	an := &analyzer{
+		g:        g,
+		nullable: make(map[NonTerminal]bool),
+		first:    make(map[NonTerminal]lookahead),
+		follow:   make(map[NonTerminal]lookahead),
+	}
+	for _, r := range g.Rules {
+		an.first[r.Name] = lookahead{}
+		an.follow[r.Name] = lookahead{}
+	}
+
+	an.computeFirst()
+	an.computeFollow()
+
+	a := &Analysis{
+		Grammar:  g,
+		Nullable: an.nullable,
+		First:    make(map[NonTerminal][]string),
+		Follow:   make(map[NonTerminal][]string),
+	}
+	for _, r := range g.Rules {
+		a.First[r.Name] = an.first[r.Name].sorted()
+		a.Follow[r.Name] = an.follow[r.Name].sorted()
+	}
+
+	for _, r := range g.Rules {
+		an.conflicts(r, r.Body, an.follow[r.Name], &a.Conflicts)
+	}
+	a.Unreachable = an.unreachable()
+	a.Unproductive = an.unproductive()
+	a.LeftRecursion = an.leftRecursion()
+
+	return a
+}
+
+func (an *analyzer) firstOf(e *Expr) (lookahead, bool) {
+	switch e.Kind {
+	case ExprNull:
+		return lookahead{}, true
+	case ExprLiteral:
+		return lookahead{strconv.Quote(e.Name): true}, false
+	case ExprClass:
+		return lookahead{"<" + e.Name + ">": true}, false
+	case ExprRef:
+		name := NonTerminal(e.Name)
+		return an.first[name], an.nullable[name]
+	case ExprSeq:
+		set := lookahead{}
+		for _, item := range e.Items {
+			first, nullable := an.firstOf(item)
+			set.addAll(first)
+			if !nullable {
+				return set, false
+			}
+		}
+		return set, true
+	case ExprAlt:
+		set, nullable := lookahead{}, false
+		for _, item := range e.Items {
+			first, n := an.firstOf(item)
+			set.addAll(first)
+			nullable = nullable || n
+		}
+		return set, nullable
+	case ExprOptional, ExprRepeat:
+		first, _ := an.firstOf(e.Items[0])
+		return first, true
+	}
+	return lookahead{}, false
+}
+
+func (an *analyzer) computeFirst() {
+	for changed := true; changed; {
+		changed = false
+		for _, r := range an.g.Rules {
+			first, nullable := an.firstOf(r.Body)
+			if an.first[r.Name].addAll(first) {
+				changed = true
+			}
+			if nullable && !an.nullable[r.Name] {
+				an.nullable[r.Name] = true
+				changed = true
+			}
+		}
+	}
+}
+
+func (an *analyzer) computeFollow() {
+	an.follow[an.g.Start][EndOfInput] = true
+
+	for changed := true; changed; {
+		changed = false
+		for _, r := range an.g.Rules {
+			if an.propagate(r.Body, an.follow[r.Name]) {
+				changed = true
+			}
+		}
+	}
+}
+
+// propagate adds next, the lookahead after e, to the FOLLOW sets of the rules
+// referenced in e.
+func (an *analyzer) propagate(e *Expr, next lookahead) (changed bool) {
+	switch e.Kind {
+	case ExprRef:
+		return an.follow[NonTerminal(e.Name)].addAll(next)
+	case ExprSeq:
+		for i := len(e.Items) - 1; i >= 0; i-- {
+			if an.propagate(e.Items[i], next) {
+				changed = true
+			}
+			first, nullable := an.firstOf(e.Items[i])
+			if nullable {
+				next = first.union(next)
+			} else {
+				next = first
+			}
+		}
+	case ExprAlt:
+		for _, item := range e.Items {
+			if an.propagate(item, next) {
+				changed = true
+			}
+		}
+	case ExprOptional:
+		changed = an.propagate(e.Items[0], next)
+	case ExprRepeat:
+		first, _ := an.firstOf(e.Items[0])
+		changed = an.propagate(e.Items[0], first.union(next))
+	}
+	return
+}
+
+func (an *analyzer) predict(e *Expr, next lookahead) lookahead {
+	first, nullable := an.firstOf(e)
+	if nullable {
+		return first.union(next)
+	}
+	return first
+}
+
+func (an *analyzer) conflicts(r *Rule, e *Expr, next lookahead, out *[]Conflict) {
+	switch e.Kind {
+	case ExprSeq:
+		nexts := make([]lookahead, len(e.Items))
+		for i := len(e.Items) - 1; i >= 0; i-- {
+			nexts[i] = next
+			next = an.predict(e.Items[i], next)
+		}
+		for i, item := range e.Items {
+			an.conflicts(r, item, nexts[i], out)
+		}
+
+	case ExprAlt:
+		predicts := make([]lookahead, len(e.Items))
+		for i, item := range e.Items {
+			predicts[i] = an.predict(item, next)
+		}
+		for i := range e.Items {
+			for j := i + 1; j < len(e.Items); j++ {
+				if shared := predicts[i].intersect(predicts[j]); len(shared) > 0 {
+					*out = append(*out, Conflict{
+						Rule:   r.Name,
+						Pos:    e.Items[j].Pos,
+						Msg:    fmt.Sprintf("alternatives `%s` and `%s` overlap", e.Items[i], e.Items[j]),
+						Tokens: shared,
+					})
+				}
+			}
+			an.conflicts(r, e.Items[i], next, out)
+		}
+
+	case ExprOptional, ExprRepeat:
+		item := e.Items[0]
+		first, _ := an.firstOf(item)
+		if shared := first.intersect(next); len(shared) > 0 {
+			*out = append(*out, Conflict{
+				Rule:   r.Name,
+				Pos:    e.Pos,
+				Msg:    fmt.Sprintf("`%s` overlaps with what follows it", e),
+				Tokens: shared,
+			})
+		}
+		if e.Kind == ExprRepeat {
+			next = first.union(next)
+		}
+		an.conflicts(r, item, next, out)
+	}
+}
+
+func (an *analyzer) unreachable() []NonTerminal {
+	seen := map[NonTerminal]bool{an.g.Start: true}
+	queue := []NonTerminal{an.g.Start}
+	for len(queue) > 0 {
+		r, ok := an.g.Rule(queue[0])
+		queue = queue[1:]
+		if !ok {
+			continue
+		}
+		walkExpr(r.Body, func(e *Expr) {
+			name := NonTerminal(e.Name)
+			if e.Kind == ExprRef && !seen[name] {
+				seen[name] = true
+				queue = append(queue, name)
+			}
+		})
+	}
+
+	names := []NonTerminal{}
+	for _, r := range an.g.Rules {
+		if !seen[r.Name] {
+			names = append(names, r.Name)
+		}
+	}
+	return names
+}
+
+func (an *analyzer) unproductive() []NonTerminal {
+	productive := map[NonTerminal]bool{}
+
+	var check func(e *Expr) bool
+	check = func(e *Expr) bool {
+		switch e.Kind {
+		case ExprRef:
+			return productive[NonTerminal(e.Name)]
+		case ExprSeq:
+			for _, item := range e.Items {
+				if !check(item) {
+					return false
+				}
+			}
+			return true
+		case ExprAlt:
+			for _, item := range e.Items {
+				if check(item) {
+					return true
+				}
+			}
+			return false
+		}
+		return true
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, r := range an.g.Rules {
+			if !productive[r.Name] && check(r.Body) {
+				productive[r.Name] = true
+				changed = true
+			}
+		}
+	}
+
+	names := []NonTerminal{}
+	for _, r := range an.g.Rules {
+		if !productive[r.Name] {
+			names = append(names, r.Name)
+		}
+	}
+	return names
+}
+
+// leftEdges returns the rules that may be entered at the start of e before any
+// token is consumed.
+func (an *analyzer) leftEdges(e *Expr, edges map[NonTerminal]bool) (nullable bool) {
+	switch e.Kind {
+	case ExprNull:
+		return true
+	case ExprRef:
+		edges[NonTerminal(e.Name)] = true
+		return an.nullable[NonTerminal(e.Name)]
+	case ExprSeq:
+		for _, item := range e.Items {
+			if !an.leftEdges(item, edges) {
+				return false
+			}
+		}
+		return true
+	case ExprAlt:
+		for _, item := range e.Items {
+			if an.leftEdges(item, edges) {
+				nullable = true
+			}
+		}
+		return nullable
+	case ExprOptional, ExprRepeat:
+		an.leftEdges(e.Items[0], edges)
+		return true
+	}
+	return false
+}
+
+// leftRecursion returns the groups of mutually left-recursive rules, in
+// grammar order. A group of one is directly left-recursive.
+func (an *analyzer) leftRecursion() [][]NonTerminal {
+	graph := map[NonTerminal]map[NonTerminal]bool{}
+	for _, r := range an.g.Rules {
+		graph[r.Name] = map[NonTerminal]bool{}
+		an.leftEdges(r.Body, graph[r.Name])
+	}
+
+	reaches := func(from, to NonTerminal) bool {
+		seen := map[NonTerminal]bool{}
+		queue := []NonTerminal{from}
+		for len(queue) > 0 {
+			cur := queue[0]
+			queue = queue[1:]
+			for next := range graph[cur] {
+				if next == to {
+					return true
+				}
+				if !seen[next] {
+					seen[next] = true
+					queue = append(queue, next)
+				}
+			}
+		}
+		return false
+	}
+
+	groups := [][]NonTerminal{}
+	grouped := map[NonTerminal]bool{}
+	for _, r := range an.g.Rules {
+		if grouped[r.Name] || !reaches(r.Name, r.Name) {
+			continue
+		}
+
+		group := []NonTerminal{}
+		for _, other := range an.g.Rules {
+			if other.Name == r.Name || (reaches(r.Name, other.Name) && reaches(other.Name, r.Name)) {
+				group = append(group, other.Name)
+				grouped[other.Name] = true
+			}
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+func (a *Analysis) String() string {
+	sb := &strings.Builder{}
+
+	nullable := []string{}
+	for _, r := range a.Grammar.Rules {
+		if a.Nullable[r.Name] {
+			nullable = append(nullable, string(r.Name))
+		}
+	}
+	fmt.Fprintf(sb, "nullable: %s\n\n", listOrNone(nullable))
+
+	for _, r := range a.Grammar.Rules {
+		fmt.Fprintf(sb, "FIRST(%s) = { %s }\n", r.Name, strings.Join(a.First[r.Name], " "))
+	}
+	sb.WriteString("\n")
+	for _, r := range a.Grammar.Rules {
+		fmt.Fprintf(sb, "FOLLOW(%s) = { %s }\n", r.Name, strings.Join(a.Follow[r.Name], " "))
+	}
+	sb.WriteString("\n")
+
+	conflicts := make([]string, len(a.Conflicts))
+	for i, c := range a.Conflicts {
+		conflicts[i] = c.String()
+	}
+	fmt.Fprintf(sb, "conflicts:%s\n", blockOrNone(conflicts))
+
+	fmt.Fprintf(sb, "unreachable: %s\n", listOrNone(symbolNames(a.Unreachable)))
+	fmt.Fprintf(sb, "unproductive: %s\n", listOrNone(symbolNames(a.Unproductive)))
+
+	recursion := make([]string, len(a.LeftRecursion))
+	for i, group := range a.LeftRecursion {
+		kind := "direct"
+		if len(group) > 1 {
+			kind = "indirect, not supported by Grow"
+		}
+		recursion[i] = fmt.Sprintf("%s (%s)", strings.Join(symbolNames(group), ", "), kind)
+	}
+	fmt.Fprintf(sb, "left recursion:%s\n", blockOrNone(recursion))
+
+	return sb.String()
+}
+
+// OK reports whether the grammar is LL(1) and free of dead or recursive rules.
+func (a *Analysis) OK() bool {
+	return len(a.Conflicts) == 0 && len(a.Unreachable) == 0 && len(a.Unproductive) == 0 && len(a.LeftRecursion) == 0
+}
+
+func symbolNames(syms []NonTerminal) []string {
+	names := make([]string, len(syms))
+	for i, sym := range syms {
+		names[i] = string(sym)
+	}
+	return names
+}
+
+func listOrNone(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, ", ")
+}
+
+func blockOrNone(items []string) string {
+	if len(items) == 0 {
+		return " none"
+	}
+	return "\n  " + strings.Join(items, "\n  ")
+}
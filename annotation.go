@@ -0,0 +1,71 @@
+package rdparser
+
+import "github.com/shivamMg/rd"
+
+type Annotation int
+
+const (
+	NoAnnotation Annotation = iota
+
+	// Inline replaces the node by its children, e.g. helper productions like
+	// Expr' whose content belongs to the parent.
+	Inline
+
+	// Hidden leaves the node and everything under it out of the tree,
+	// including the text and trivia of its tokens.
+	Hidden
+
+	// List flattens right-recursive lists like `FuncArg -> Expr "," FuncArg`
+	// into one node: nested nodes of the same symbol are spliced in and
+	// terminal children such as separators are dropped along with their
+	// trivia.
+	List
+)
+
+type Annotator interface {
+	Annotation(sym NonTerminal) Annotation
+}
+
+type Annotations map[NonTerminal]Annotation
+
+func (a Annotations) Annotation(sym NonTerminal) Annotation {
+	return a[sym]
+}
+
+// WithAnnotations makes Compile shape the tree as the annotator says while it
+// is being built. Grammars keep matching the full productions, but consumers
+// of the tree see the simplified shape.
+func WithAnnotations(a Annotator) Option {
+	return func(o *options) {
+		o.annotator = a
+	}
+}
+
+// attach adds the node of a successful non-terminal to its parent.
+func (b *Builder) attach(parent *frame, node *rd.Tree) {
+	sym, ok := node.Symbol.(NonTerminal)
+	if b.annotator == nil || !ok || parent.sym == rootSymbol {
+		parent.node.Add(node)
+		return
+	}
+
+	switch b.annotator.Annotation(sym) {
+	case Inline:
+		parent.node.Subtrees = append(parent.node.Subtrees, node.Subtrees...)
+	case Hidden:
+	case List:
+		items := []*rd.Tree{}
+		for _, sub := range node.Subtrees {
+			switch {
+			case IsNonTerminalOf(sub.Symbol, sym):
+				items = append(items, sub.Subtrees...)
+			case !IsTerminal(sub.Symbol):
+				items = append(items, sub)
+			}
+		}
+		node.Subtrees = items
+		parent.node.Add(node)
+	default:
+		parent.node.Add(node)
+	}
+}
@@ -2,37 +2,376 @@ package rdparser
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/shivamMg/rd"
 )
 
+// Builder builds the parse tree for the rules of a Grammar. It used to embed
+// *rd.Builder; it now keeps its own stack so that memoization can replay
+// subtrees. The methods it had keep their names and signatures, except that
+// DebugTree and Err return *DebugTree and *SyntaxError, which have the methods
+// of *rd.DebugTree and *rd.ParsingError. The embedded Builder field has no
+// replacement.
 type Builder struct {
-	*rd.Builder
+	tokens  []rd.Token
+	current int
+	stack   []*frame
+	final   *rd.Tree
+	skip    bool
 
 	last rd.Token
+	end  Position
+
+	recovering bool
+	errors     []*SyntaxError
+
+	memo    map[memoKey]*memoEntry
+	growing map[memoKey]*growth
+
+	annotator Annotator
+	tracer    Tracer
+	coverage  *Coverage
+	maxDepth  int
+
+	debugTree *DebugTree
+	err       *SyntaxError
+}
+
+type frame struct {
+	sym      interface{}
+	index    int
+	node     *rd.Tree
+	debug    *DebugTree
+	errors   int
+	recalled bool
+}
+
+func newBuilder(tokens []rd.Token, o *options) *Builder {
+	b := &Builder{
+		tokens:    tokens,
+		current:   -1,
+		annotator: o.annotator,
+		tracer:    o.tracer,
+		maxDepth:  o.maxDepth,
+	}
+
+	if o.memoize {
+		b.memo = make(map[memoKey]*memoEntry)
+	}
+
+	if len(tokens) > 0 {
+		if tok, ok := tokens[len(tokens)-1].(Token); ok {
+			b.end = tok.Pos
+			b.end.Offset += len(tok.Text)
+			b.end.Column += len(tok.Text)
+		}
+	}
+
+	return b
 }
 
 func (b *Builder) Enter(ctx *context.Context, sym NonTerminal) *Builder {
-	b.Builder.Enter(sym)
+	b.enter(sym)
+	b.emit(Event{Kind: EventEnter, Symbol: sym, Depth: b.depth()})
 	if ctx != nil {
 		*ctx = Trace(*ctx, sym)
 	}
 	return b
 }
 
+func (b *Builder) enter(sym interface{}) {
+	if b.maxDepth > 0 && len(b.stack) > b.maxDepth {
+		panic(&LimitError{Pos: b.pos(), Limit: "levels of nesting", Max: b.maxDepth})
+	}
+	b.stack = append(b.stack, &frame{
+		sym:    sym,
+		index:  b.current,
+		node:   rd.NewTree(sym),
+		debug:  &DebugTree{data: fmt.Sprint(sym)},
+		errors: len(b.errors),
+	})
+}
+
+// Exit leaves the non-terminal entered last. On success the node is added to
+// its parent, otherwise the input position is restored and any syntax errors
+// reported inside it are discarded.
+func (b *Builder) Exit(result *bool) {
+	if result == nil {
+		panic("Exit result cannot be nil")
+	}
+
+	f := b.frame()
+	if sym, ok := f.sym.(NonTerminal); ok && sym != rootSymbol && sym != ErrorSymbol {
+		b.emit(Event{Kind: EventExit, Symbol: sym, Depth: b.depth(), OK: *result})
+	}
+	b.stack = b.stack[:len(b.stack)-1]
+
+	f.debug.data += fmt.Sprintf("(%t)", *result)
+	if len(b.stack) == 1 && f.sym != ErrorSymbol {
+		b.err = b.startErr(*result)
+	}
+	if len(b.stack) == 0 {
+		b.debugTree = f.debug
+	} else {
+		parent := b.frame().debug
+		parent.subtrees = append(parent.subtrees, f.debug)
+	}
+
+	if b.memo != nil && !f.recalled && !b.skip {
+		b.store(f, *result)
+	}
+
+	switch {
+	case b.skip:
+		b.skip = false
+		b.current = f.index
+	case *result && len(b.stack) == 0:
+		b.final = f.node
+	case *result:
+		b.attach(b.frame(), f.node)
+	default:
+		b.current = f.index
+		b.errors = b.errors[:f.errors]
+	}
+}
+
+func (b *Builder) Peek(i int) (rd.Token, bool) {
+	j := b.current + i
+	if j < 0 || j >= len(b.tokens) {
+		return nil, false
+	}
+	return b.tokens[j], true
+}
+
+func (b *Builder) Check(token rd.Token, i int) bool {
+	next, ok := b.Peek(i)
+	return ok && IsTerminalOf(next, terminalOf(token))
+}
+
+func (b *Builder) CheckOrNotOK(token rd.Token, i int) bool {
+	next, ok := b.Peek(i)
+	return !ok || IsTerminalOf(next, terminalOf(token))
+}
+
+func (b *Builder) Next() (rd.Token, bool) {
+	if b.current == len(b.tokens)-1 {
+		return nil, false
+	}
+	b.current++
+	return b.tokens[b.current], true
+}
+
+func (b *Builder) Backtrack() {
+	f := b.frame()
+	if sym, ok := f.sym.(NonTerminal); ok {
+		b.emit(Event{Kind: EventBacktrack, Symbol: sym, Depth: b.depth()})
+	}
+	b.current = f.index
+	f.node.Subtrees = []*rd.Tree{}
+	b.errors = b.errors[:f.errors]
+}
+
+func (b *Builder) Skip() {
+	b.skip = true
+}
+
 func (b *Builder) Match(token rd.Token) bool {
-	if b.Builder.Match(token) {
-		b.last = token
-		return true
+	sym, ok := TerminalOf(token)
+	if !ok {
+		return false
 	}
-	return false
+
+	next, ok := b.Peek(1)
+	if !ok || !IsTerminalOf(next, sym) {
+		b.emit(Event{Kind: EventMatch, Symbol: sym, Depth: b.depth() + 1})
+		b.debugMatch(next, token, false)
+		return false
+	}
+
+	b.debugMatch(next, token, true)
+	b.emit(Event{Kind: EventMatch, Symbol: sym, Depth: b.depth() + 1, Text: textOf(next), OK: true})
+	b.Next()
+	b.Add(next)
+	return true
 }
 
 func (b *Builder) Add(token rd.Token) {
-	b.Builder.Add(token)
+	b.frame().node.Add(rd.NewTree(token))
 	b.last = token
 }
 
 func (b *Builder) Last() rd.Token {
 	return b.last
 }
+
+func (b *Builder) ParseTree() *rd.Tree {
+	return b.final
+}
+
+func (b *Builder) Recovering() bool {
+	return b.recovering
+}
+
+// Expect matches token like Match. In recovery mode a missing token is
+// reported and inserted into the tree so that parsing can carry on.
+func (b *Builder) Expect(ctx context.Context, token rd.Token) bool {
+	if b.Match(token) {
+		return true
+	}
+
+	if !b.recovering {
+		return false
+	}
+
+	sym, _ := TerminalOf(token)
+	pos := b.pos()
+	b.report(pos, fmt.Sprintf("expecting `%s`", sym))
+	b.Add(Token{Terminal: sym, Pos: pos})
+	return true
+}
+
+// Recover reports msg and, in recovery mode, skips tokens up to the next one
+// found in sync, collecting them under an ErrorSymbol node.
+func (b *Builder) Recover(ctx context.Context, msg string, sync ...rd.Token) bool {
+	if !b.recovering {
+		return false
+	}
+
+	b.report(b.pos(), msg)
+	b.skipTo(sync)
+	return true
+}
+
+// Optional runs rule and undoes whatever it consumed if it fails. Like Choice
+// and Repeat, the nodes it builds are added to the current non-terminal.
+func (b *Builder) Optional(rule func() bool) bool {
+	m := b.mark()
+	if !rule() {
+		b.reset(m)
+	}
+	return true
+}
+
+// Repeat runs rule until it fails or stops consuming input.
+func (b *Builder) Repeat(rule func() bool) bool {
+	for {
+		m := b.mark()
+		if !rule() || b.current == m.current {
+			b.reset(m)
+			return true
+		}
+	}
+}
+
+// Choice tries each alternative in order from the same position and stops at
+// the first that succeeds.
+func (b *Builder) Choice(alts ...func() bool) bool {
+	m := b.mark()
+	for _, alt := range alts {
+		if alt() {
+			return true
+		}
+		b.reset(m)
+	}
+	return false
+}
+
+func (b *Builder) Errors() []*SyntaxError {
+	return b.errors
+}
+
+func (b *Builder) frame() *frame {
+	if len(b.stack) == 0 {
+		panic("must Enter a non-terminal first")
+	}
+	return b.stack[len(b.stack)-1]
+}
+
+func (b *Builder) report(pos Position, msg string) {
+	if sym, ok := b.frame().sym.(NonTerminal); ok {
+		b.emit(Event{Kind: EventError, Symbol: sym, Depth: b.depth(), Pos: pos, Msg: msg})
+	}
+	b.errors = append(b.errors, &SyntaxError{Pos: pos, Msg: msg})
+}
+
+func (b *Builder) skipTo(sync []rd.Token) {
+	ok := true
+	b.enter(ErrorSymbol)
+	defer b.Exit(&ok)
+
+	for {
+		next, found := b.Peek(1)
+		if !found || isSync(next, sync) {
+			return
+		}
+		b.Next()
+		b.frame().node.Add(rd.NewTree(next))
+	}
+}
+
+func (b *Builder) pos() Position {
+	if tok, ok := b.Peek(1); ok {
+		return PositionOf(tok)
+	}
+	return b.end
+}
+
+func (b *Builder) tree() *Tree {
+	root := b.ParseTree()
+	if root == nil || len(root.Subtrees) == 0 {
+		return nil
+	}
+
+	top := root.Subtrees[0]
+	for _, extra := range root.Subtrees[1:] {
+		top.Add(extra)
+	}
+
+	return &Tree{Tree: top}
+}
+
+func isSync(tok rd.Token, sync []rd.Token) bool {
+	for _, s := range sync {
+		if IsTerminalOf(tok, terminalOf(s)) {
+			return true
+		}
+	}
+	return false
+}
+
+func textOf(tok rd.Token) string {
+	if v, ok := tok.(Token); ok {
+		return v.Text
+	}
+	return terminalOf(tok).String()
+}
+
+func terminalOf(tok rd.Token) Terminal {
+	sym, _ := TerminalOf(tok)
+	return sym
+}
+
+type mark struct {
+	current  int
+	children int
+	errors   int
+	last     rd.Token
+}
+
+func (b *Builder) mark() mark {
+	return mark{
+		current:  b.current,
+		children: len(b.frame().node.Subtrees),
+		errors:   len(b.errors),
+		last:     b.last,
+	}
+}
+
+func (b *Builder) reset(m mark) {
+	f := b.frame()
+	b.current = m.current
+	f.node.Subtrees = f.node.Subtrees[:m.children]
+	b.errors = b.errors[:m.errors]
+	b.last = m.last
+}
@@ -0,0 +1,617 @@
+package rdparser
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/shivamMg/ppds/tree"
+	"github.com/shivamMg/rd"
+)
+
+const (
+	testExpr NonTerminal = "Expr"
+	testTerm NonTerminal = "Term"
+	testNum  NonTerminal = "Num"
+)
+
+// testGrammar is the left-recursive grammar
+//
+//	Expr -> Expr "-" Term | Term
+//	Term -> Term "/" Num | Num
+//	Num  -> <digit>
+type testGrammar struct{}
+
+func (g *testGrammar) BuildParseTree(ctx context.Context, b *Builder) error {
+	if !g.Expr(ctx, b) {
+		return NewSyntaxError(ctx, b)
+	}
+	return nil
+}
+
+func (g *testGrammar) Expr(ctx context.Context, b *Builder) (ok bool) {
+	defer b.Enter(&ctx, testExpr).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	return b.Grow(func() bool {
+		if g.Expr(ctx, b) && b.Match(Terminal("-")) && g.Term(ctx, b) {
+			return true
+		}
+		b.Backtrack()
+		return g.Term(ctx, b)
+	})
+}
+
+func (g *testGrammar) Term(ctx context.Context, b *Builder) (ok bool) {
+	defer b.Enter(&ctx, testTerm).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	return b.Grow(func() bool {
+		if g.Term(ctx, b) && b.Match(Terminal("/")) && g.Num(ctx, b) {
+			return true
+		}
+		b.Backtrack()
+		return g.Num(ctx, b)
+	})
+}
+
+func (g *testGrammar) Num(ctx context.Context, b *Builder) (ok bool) {
+	defer b.Enter(&ctx, testNum).Exit(&ok)
+
+	tok, ok := b.Next()
+	if !ok {
+		return false
+	}
+
+	if sym, _ := TerminalOf(tok); sym >= "0" && sym <= "9" {
+		b.Add(tok)
+		return true
+	}
+
+	return false
+}
+
+func testTokens(input string) []rd.Token {
+	tokens := []rd.Token{}
+	for _, s := range strings.Fields(input) {
+		tokens = append(tokens, Terminal(s))
+	}
+	return tokens
+}
+
+func bracket(t *Tree) string {
+	if t.IsTerminal() {
+		return t.AsTerminal().String()
+	}
+	if t.Len() == 1 {
+		return bracket(t.At(0))
+	}
+
+	parts := []string{}
+	for i := 0; i < t.Len(); i++ {
+		parts = append(parts, bracket(t.At(i)))
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+func TestLeftRecursion(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"1", "1"},
+		{"1 - 2", "(1 - 2)"},
+		{"1 - 2 - 3", "((1 - 2) - 3)"},
+		{"8 / 4 / 2 - 1 - 6 / 3", "((((8 / 4) / 2) - 1) - (6 / 3))"},
+	}
+
+	for _, c := range cases {
+		for _, opts := range [][]Option{nil, {WithMemoization()}} {
+			tree, err := Compile(testTokens(c.input), &testGrammar{}, opts...)
+			if err != nil {
+				t.Errorf("%s: %v", c.input, err)
+				continue
+			}
+
+			if actual := bracket(tree); actual != c.expected {
+				t.Errorf("%s: expected %s, got %s", c.input, c.expected, actual)
+			}
+		}
+	}
+
+	if _, err := Compile(testTokens("1 - - 2"), &testGrammar{}); err == nil {
+		t.Errorf("expected syntax error")
+	}
+}
+
+type testPratt struct {
+	ops *Operators
+}
+
+func newTestPratt() *testPratt {
+	ops := NewOperators().
+		Ternary(Terminal("?"), Terminal(":"), 1, "Cond").
+		Infix(Terminal("="), 2, AssocNone, "Eq").
+		Infix(Terminal("+"), 3, AssocLeft, "Add").
+		Infix(Terminal("-"), 3, AssocLeft, "Sub").
+		Infix(Terminal("*"), 4, AssocLeft, "Mul").
+		Infix(Terminal("^"), 6, AssocRight, "Pow").
+		Prefix(Terminal("-"), 5, "Neg").
+		Postfix(Terminal("!"), 7, "Fact")
+	return &testPratt{ops: ops}
+}
+
+func (g *testPratt) BuildParseTree(ctx context.Context, b *Builder) error {
+	if !g.Expr(ctx, b) {
+		return NewSyntaxError(ctx, b)
+	}
+	return nil
+}
+
+func (g *testPratt) Expr(ctx context.Context, b *Builder) (ok bool) {
+	defer b.Enter(&ctx, testExpr).Exit(&ok)
+
+	return g.ops.Parse(ctx, b, (&testGrammar{}).Num)
+}
+
+func TestOperators(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"1", "1"},
+		{"1 + 2 * 3", "(1 + (2 * 3))"},
+		{"1 - 2 + 3", "((1 - 2) + 3)"},
+		{"2 ^ 3 ^ 2", "(2 ^ (3 ^ 2))"},
+		{"- 2 ^ 2", "(- (2 ^ 2))"},
+		{"- 2 * 3", "((- 2) * 3)"},
+		{"3 ! ^ 2", "((3 !) ^ 2)"},
+		{"1 = 2 ? 3 : 4 ? 5 : 6", "((1 = 2) ? 3 : (4 ? 5 : 6))"},
+	}
+
+	for _, c := range cases {
+		tree, err := Compile(testTokens(c.input), newTestPratt())
+		if err != nil {
+			t.Errorf("%s: %v", c.input, err)
+			continue
+		}
+
+		if actual := bracket(tree); actual != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.input, c.expected, actual)
+		}
+	}
+
+	for _, input := range []string{"1 = 2 = 3", "1 +", "1 ? 2"} {
+		if _, err := Compile(testTokens(input), newTestPratt()); err == nil {
+			t.Errorf("%s: expected syntax error", input)
+		}
+	}
+}
+
+const testEBNF = `
+# arithmetic with repetition, grouping and left recursion
+Expr   -> Expr "-" Term | Term
+Term   -> Factor { ("*" | "/") Factor }
+Factor -> "(" Expr ")" | [ "+" ] Num
+Num    -> <digit>
+`
+
+func TestEBNF(t *testing.T) {
+	g := MustParseEBNF(testEBNF).Class("digit", func(tok rd.Token) bool {
+		sym, _ := TerminalOf(tok)
+		return sym >= "0" && sym <= "9"
+	})
+
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"1", "1"},
+		{"+ 1", "(+ 1)"},
+		{"1 - 2 - 3", "((1 - 2) - 3)"},
+		{"1 * 2 / 3 - 4", "((1 * 2 / 3) - 4)"},
+		{"( 1 - 2 ) * 3", "((( (1 - 2) )) * 3)"},
+	}
+
+	for _, c := range cases {
+		for _, opts := range [][]Option{nil, {WithMemoization()}} {
+			tree, err := Compile(testTokens(c.input), g, opts...)
+			if err != nil {
+				t.Errorf("%s: %v", c.input, err)
+				continue
+			}
+
+			if actual := bracket(tree); actual != c.expected {
+				t.Errorf("%s: expected %s, got %s", c.input, c.expected, actual)
+			}
+		}
+	}
+
+	if _, err := Compile(testTokens("1 * * 2"), g); err == nil {
+		t.Errorf("expected syntax error")
+	}
+
+	if _, err := Compile(testTokens("1"), MustParseEBNF(testEBNF)); !errors.Is(err, ErrGrammar) {
+		t.Errorf("expected grammar error for undefined class, got %v", err)
+	}
+
+	for _, src := range []string{`A -> B`, `A -> "a" A -> "b"`, `A -> ( "a"`, `-> "a"`, `A -> "a`} {
+		if _, err := ParseEBNF(src); !errors.Is(err, ErrGrammar) {
+			t.Errorf("%s: expected grammar error, got %v", src, err)
+		}
+	}
+
+	if actual := MustParseEBNF(testEBNF).String(); !strings.Contains(actual, `Term -> Factor { ( "*" | "/" ) Factor }`) {
+		t.Errorf("unexpected grammar text:\n%s", actual)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	a := Analyze(MustParseEBNF(`
+S -> A "x" | B
+A -> B "y" | [ "z" ]
+B -> A "w" | "v"
+C -> "c"
+D -> D "d"
+`))
+
+	if !a.Nullable["A"] || a.Nullable["B"] {
+		t.Errorf("unexpected nullable set %v", a.Nullable)
+	}
+
+	if actual := strings.Join(a.First["S"], " "); actual != `"v" "w" "x" "z"` {
+		t.Errorf("unexpected FIRST(S) = %s", actual)
+	}
+
+	if actual := strings.Join(a.Follow["B"], " "); actual != `"y" $` {
+		t.Errorf("unexpected FOLLOW(B) = %s", actual)
+	}
+
+	if len(a.Conflicts) == 0 || a.Conflicts[0].Rule != "S" {
+		t.Errorf("expected a conflict in S, got %v", a.Conflicts)
+	}
+
+	if len(a.Unreachable) != 2 || a.Unreachable[0] != "C" || a.Unreachable[1] != "D" {
+		t.Errorf("unexpected unreachable rules %v", a.Unreachable)
+	}
+
+	if len(a.Unproductive) != 1 || a.Unproductive[0] != "D" {
+		t.Errorf("unexpected unproductive rules %v", a.Unproductive)
+	}
+
+	if len(a.LeftRecursion) != 2 || len(a.LeftRecursion[0]) != 2 || a.LeftRecursion[1][0] != "D" {
+		t.Errorf("unexpected left recursion %v", a.LeftRecursion)
+	}
+
+	if a.OK() || !Analyze(MustParseEBNF(`S -> "a" S | NULL`)).OK() {
+		t.Errorf("unexpected OK result")
+	}
+}
+
+func TestExport(t *testing.T) {
+	tree, err := Compile(testTokens(`1 - 2`), &testGrammar{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dot := tree.DOT()
+	for _, s := range []string{"digraph tree {", `n0 [label="Expr"];`, `[label="-", shape=box];`, "n0 -> n1;"} {
+		if !strings.Contains(dot, s) {
+			t.Errorf("DOT lacks %q:\n%s", s, dot)
+		}
+	}
+
+	mermaid := tree.Mermaid(CollapseChains())
+	for _, s := range []string{"graph TD", `n0("Expr")`, `("Expr / Term / Num")`, `["2"]`} {
+		if !strings.Contains(mermaid, s) {
+			t.Errorf("Mermaid lacks %q:\n%s", s, mermaid)
+		}
+	}
+
+	empty := &Tree{Tree: rd.NewTree(testExpr)}
+	empty.Add(rd.NewTree(testTerm))
+	if actual := empty.DOT(CollapseNull()); strings.Contains(actual, "Term") {
+		t.Errorf("expected NULL production to be left out:\n%s", actual)
+	}
+}
+
+func TestVisitor(t *testing.T) {
+	tree, err := Compile(testTokens(`8 / 4 - 2 - 1`), &testGrammar{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := []string{}
+	err = NewVisitor().
+		Pre(testTerm, func(t *Tree) error {
+			order = append(order, "pre")
+			if t.Len() > 1 {
+				return SkipChildren
+			}
+			return nil
+		}).
+		Post(testTerm, func(t *Tree) error {
+			order = append(order, "post")
+			return nil
+		}).
+		Leaf(func(t *Tree) error {
+			order = append(order, t.AsTerminal().String())
+			if t.IsTerminalOf("1") {
+				return SkipAll
+			}
+			return nil
+		}).
+		Walk(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actual := strings.Join(order, " "); actual != "pre - pre 2 post - pre 1" {
+		t.Errorf("unexpected visiting order: %s", actual)
+	}
+
+	redacted, err := NewRewriter().
+		Rule(testNum, func(t *Tree) (*Tree, error) {
+			return &Tree{Tree: rd.NewTree(testNum)}, nil
+		}).
+		Rule(testTerm, func(t *Tree) (*Tree, error) {
+			if t.Len() == 1 && t.At(0).Len() == 0 {
+				return nil, nil
+			}
+			return t, nil
+		}).
+		Rewrite(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actual := bracket(redacted); actual != "(((/ ()) -) -)" {
+		t.Errorf("unexpected rewritten tree %s", actual)
+	}
+
+	if bracket(tree) != "(((8 / 4) - 2) - 1)" || bracket(tree.Clone()) != bracket(tree) {
+		t.Errorf("rewriting modified the original tree: %s", bracket(tree))
+	}
+}
+
+func TestTracer(t *testing.T) {
+	text := &strings.Builder{}
+	if _, err := Compile(testTokens("1 - 2"), &testGrammar{}, WithTracer(NewTextTracer(text))); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		"enter Expr at -",
+		"  enter Term at -",
+		`  match "-" at - failed`,
+		"  backtrack Term at -",
+		"exit Expr ok, next at -",
+	} {
+		if !strings.Contains(text.String(), line+"\n") {
+			t.Errorf("trace lacks %q:\n%s", line, text)
+		}
+	}
+
+	events := []Event{}
+	lines := &strings.Builder{}
+	tracer := TracerFunc(func(e Event) {
+		events = append(events, e)
+		NewJSONTracer(lines).Event(e)
+	})
+	if _, err := Compile(testTokens("1 - 2"), &testGrammar{}, WithTracer(tracer), WithMemoization()); err != nil {
+		t.Fatal(err)
+	}
+
+	recalls := 0
+	for _, e := range events {
+		if e.Kind == EventRecall {
+			recalls++
+		}
+	}
+	if recalls == 0 {
+		t.Errorf("expected recall events with memoization")
+	}
+
+	for i, line := range strings.Split(strings.TrimSpace(lines.String()), "\n") {
+		var v map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", line, err)
+		}
+		if v["event"] != events[i].Kind.String() {
+			t.Errorf("line %d: expected %s event, got %v", i, events[i].Kind, v["event"])
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	var limitErr *LimitError
+
+	_, err := Compile(testTokens("1 - 2 - 3"), &testGrammar{}, WithMaxTokens(3), WithRecovery())
+	if !errors.As(err, &limitErr) || limitErr.Limit != "tokens" {
+		t.Errorf("expected token limit error, got %v", err)
+	}
+
+	_, err = Compile(testTokens("1 - 2"), &testGrammar{}, WithMaxDepth(2), WithRecovery(), WithMemoization())
+	if !errors.Is(err, ErrLimit) || !errors.As(err, &limitErr) || limitErr.Limit != "levels of nesting" {
+		t.Errorf("expected nesting limit error, got %v", err)
+	}
+
+	if _, err := Compile(testTokens("1 - 2"), &testGrammar{}, WithMaxTokens(3), WithMaxDepth(3)); err != nil {
+		t.Errorf("unexpected error within limits: %v", err)
+	}
+
+	ctx := Trace(Trace(Trace(context.Background(), testExpr), testTerm), testNum)
+	if depth := TraceDepth(ctx); depth != 3 {
+		t.Errorf("unexpected trace depth %d", depth)
+	}
+	if st := GetStackTrace(ctx).String(); st != "Expr > Term > Num" {
+		t.Errorf("unexpected stack trace %s", st)
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	g := MustParseEBNF(testEBNF).Class("digit", func(tok rd.Token) bool {
+		sym, _ := TerminalOf(tok)
+		return sym >= "0" && sym <= "9"
+	})
+
+	cov := NewCoverage()
+	for _, input := range []string{"1 - 2", "( 1 )", "1 -"} {
+		Compile(testTokens(input), g, WithCoverage(cov), WithMemoization())
+	}
+
+	if cov.Entered(testExpr) == 0 || cov.Matched(testExpr) == 0 || cov.Terminal("-") != 1 {
+		t.Errorf("unexpected counts: entered %d, matched %d, %d `-`", cov.Entered(testExpr), cov.Matched(testExpr), cov.Terminal("-"))
+	}
+
+	report := cov.Report(g)
+	if actual := strings.Join(report.Untested(), ", "); actual != `"*", "+", "/"` {
+		t.Errorf("unexpected untested productions %s:\n%s", actual, report)
+	}
+
+	Compile(testTokens("+ 1 * 2"), g, WithCoverage(cov))
+	report = cov.Report(g, "/", "*", "/")
+	if actual := strings.Join(report.Untested(), ", "); actual != `"/"` {
+		t.Errorf("unexpected untested productions %s:\n%s", actual, report)
+	}
+
+	// A recovered input counts once, and neither the <error> node nor the
+	// node holding it counts as a production.
+	clean, recovered := NewCoverage(), NewCoverage()
+	Compile(testTokens("1 - 2"), g, WithCoverage(clean))
+	Compile(testTokens("1 - 2 3"), g, WithCoverage(recovered), WithRecovery())
+	if clean.Entered(testExpr) != recovered.Entered(testExpr) || recovered.Terminal("3") != 0 {
+		t.Errorf("expected %d entries of Expr, got %d, and %d `3`", clean.Entered(testExpr), recovered.Entered(testExpr), recovered.Terminal("3"))
+	}
+	if c := recovered.Report(g).Rules[0]; c.Alternatives[0].Count != 0 || c.Alternatives[1].Count != 1 {
+		t.Errorf("unexpected Expr coverage with an error node:\n%s", recovered.Report(g))
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	g := MustParseEBNF(testEBNF).Class("digit", func(tok rd.Token) bool {
+		sym, _ := TerminalOf(tok)
+		return sym >= "0" && sym <= "9"
+	})
+
+	gen := NewGenerator(g, rand.New(rand.NewSource(1))).
+		MaxDepth(3).
+		Weight(Terminal("("), 3).
+		Class("digit", func(r *rand.Rand) string {
+			return strconv.Itoa(r.Intn(10))
+		})
+
+	for i := 0; i < 100; i++ {
+		s, err := gen.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Compile(testTokens(s), g); err != nil {
+			t.Errorf("%s: %v", s, err)
+		}
+	}
+
+	gen.Weight(Terminal("("), 0)
+	if s, _ := gen.Generate(); strings.Contains(s, "(") {
+		t.Errorf("unexpected parentheses in %s", s)
+	}
+
+	if _, err := NewGenerator(MustParseEBNF(testEBNF), rand.New(rand.NewSource(1))).Generate(); !errors.Is(err, ErrGrammar) {
+		t.Errorf("expected grammar error for undefined class, got %v", err)
+	}
+}
+
+type debugGrammar struct {
+	testGrammar
+	b *Builder
+}
+
+func (g *debugGrammar) BuildParseTree(ctx context.Context, b *Builder) error {
+	g.b = b
+	return g.testGrammar.BuildParseTree(ctx, b)
+}
+
+func TestDebugTree(t *testing.T) {
+	g := &debugGrammar{}
+	if _, err := Compile(testTokens("1 - 2"), g); err != nil {
+		t.Fatal(err)
+	}
+	if g.b.Err() != nil {
+		t.Errorf("unexpected error %v", g.b.Err())
+	}
+	if dt := g.b.DebugTree(); dt == nil || dt.Data() != "Expr(true)" || !strings.Contains(dt.String(), "\n  - = -\n") {
+		t.Errorf("unexpected debug tree:\n%s", dt)
+	} else if _, err := tree.SprintWithError(dt); err != nil || len(dt.Children()) == 0 {
+		t.Errorf("debug tree does not print as a tree.Node: %v", err)
+	}
+
+	Compile(testTokens("1 2"), g)
+	if err := g.b.Err(); err == nil || err.Msg != "unexpected token `2`" {
+		t.Errorf("expected unconsumed token error, got %v", err)
+	}
+}
+
+// strictGrammar only fails outside recovery mode.
+type strictGrammar struct {
+	testGrammar
+}
+
+func (g *strictGrammar) BuildParseTree(ctx context.Context, b *Builder) error {
+	if err := g.testGrammar.BuildParseTree(ctx, b); err != nil || b.Recovering() {
+		return err
+	}
+	return NewError(ErrCompile, "strict")
+}
+
+func TestCompile(t *testing.T) {
+	var syntaxErr *SyntaxError
+	tree, err := Compile(testTokens("1 - 2 3"), &testGrammar{})
+	if tree != nil || !errors.As(err, &syntaxErr) || syntaxErr.Msg != "unexpected token `3`" {
+		t.Errorf("expected trailing token error without a tree, got %v, %v", tree, err)
+	}
+
+	var syntaxErrs *SyntaxErrors
+	tree, err = Compile(testTokens("1 - 2 3"), &testGrammar{}, WithRecovery())
+	if tree == nil || !errors.As(err, &syntaxErrs) || len(syntaxErrs.Errors) != 1 {
+		t.Errorf("expected partial tree and one error with recovery, got %v, %v", tree, err)
+	}
+
+	tree, err = Compile(testTokens("1 - 2"), &strictGrammar{}, WithRecovery())
+	if tree != nil || !errors.Is(err, ErrCompile) {
+		t.Errorf("expected the first error without a tree, got %v, %v", tree, err)
+	}
+
+	eof := Token{Terminal: EOF, Pos: Position{Line: 1, Column: 6, Offset: 5}, Leading: "  # c"}
+	tree, err = Compile([]rd.Token{eof}, MustParseEBNF(`S -> "a" S | NULL`))
+	if err != nil || tree.Source() != "  # c" {
+		t.Errorf("expected the trivia of the EOF token in the tree, got %v, %v", tree, err)
+	}
+
+	tree, err = Compile([]rd.Token{eof}, &testGrammar{})
+	if tree != nil || !errors.As(err, &syntaxErr) || syntaxErr.Pos != eof.Pos {
+		t.Errorf("expected a syntax error at the EOF token, got %v, %v", tree, err)
+	}
+}
+
+func TestQueryStrings(t *testing.T) {
+	root := rd.NewTree(testExpr)
+	for _, s := range []string{`it's`, `say "hi"`, `back\slash`} {
+		root.Add(rd.NewTree(Terminal(s)))
+	}
+	tree := &Tree{Tree: root}
+
+	for _, query := range []string{`'it\'s'`, `"it's"`, `'say "hi"'`, `'say \"hi\"'`, `"say \"hi\""`, `'back\\slash'`} {
+		matches, err := tree.Query(query)
+		if err != nil || len(matches) != 1 {
+			t.Errorf("%s: expected one match, got %d (%v)", query, len(matches), err)
+		}
+	}
+}
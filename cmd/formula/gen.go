@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/michaelrk02/rdparser"
+	"github.com/michaelrk02/rdparser/pkg/formula"
+)
+
+// gen prints random formulas, one per line: formula gen [flags].
+func gen(args []string, varDict formula.VariableDict) {
+	var count, depth int
+	var seed int64
+	var weights, functions string
+	var noVars bool
+
+	flags := flag.NewFlagSet("gen", flag.ExitOnError)
+	flags.IntVar(&count, "n", 10, "number of formulas to generate")
+	flags.IntVar(&depth, "depth", 10, "maximum nesting of grammar rules")
+	flags.Int64Var(&seed, "seed", 0, "random seed (default: from the clock)")
+	flags.StringVar(&weights, "weights", "", "comma-separated weights of operators and rules, e.g. +=3,*=2,BoolCond=0")
+	flags.StringVar(&functions, "functions", "", "comma-separated functions to call instead of the whole standard library")
+	flags.BoolVar(&noVars, "novars", false, "leave variables out")
+	flags.Parse(args)
+
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	opts := []formula.GeneratorOption{formula.WithSeed(seed), formula.WithDepth(depth)}
+	if !noVars {
+		opts = append(opts, formula.WithVariables(varDict))
+	}
+	if functions != "" {
+		opts = append(opts, formula.WithFunctions(strings.Split(functions, ",")...))
+	}
+
+	if weights != "" {
+		for _, pair := range strings.Split(weights, ",") {
+			i := strings.LastIndex(pair, "=")
+			if i <= 0 {
+				fmt.Fprintf(os.Stderr, "invalid weight %q\n", pair)
+				os.Exit(2)
+			}
+			w, err := strconv.ParseFloat(pair[i+1:], 64)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "invalid weight %q: %v\n", pair, err)
+				os.Exit(2)
+			}
+			opts = append(opts, formula.WithWeight(weightSymbol(pair[:i]), w))
+		}
+	}
+
+	g := formula.NewGenerator(formula.NewStdLibrary(), opts...)
+	for i := 0; i < count; i++ {
+		expr, err := g.Generate()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(expr)
+	}
+}
+
+// weightSymbol reads names starting with an upper case letter as rules and
+// anything else as operators.
+func weightSymbol(name string) interface{} {
+	if name[0] >= 'A' && name[0] <= 'Z' {
+		return rdparser.NonTerminal(name)
+	}
+	return rdparser.Terminal(strings.ToLower(name))
+}
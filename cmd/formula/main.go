@@ -5,17 +5,38 @@ import (
 	"flag"
 	"fmt"
 	"math"
+	"os"
+	"time"
 
 	"github.com/michaelrk02/rdparser"
 	"github.com/michaelrk02/rdparser/pkg/formula"
 )
 
+var varDict = formula.VariableDict{
+	"pi":  math.Pi,
+	"e":   math.E,
+	"nan": math.NaN(),
+	"inf": math.Inf(1),
+}
+
 func main() {
-	var expr string
+	if len(os.Args) > 1 && os.Args[1] == "gen" {
+		gen(os.Args[2:], varDict)
+		return
+	}
+
+	var expr, treeFormat, traceFormat, explainFormat string
 	var epsilon float64
+	var collapse bool
+	var timeout time.Duration
 
 	flag.StringVar(&expr, "expr", "", "expression")
 	flag.Float64Var(&epsilon, "epsilon", 0.0, "use this epsilon (error-tolerance) value")
+	flag.DurationVar(&timeout, "timeout", 0, "stop evaluating after this duration")
+	flag.StringVar(&treeFormat, "tree", "", "print the parse tree as dot or mermaid instead of evaluating")
+	flag.StringVar(&traceFormat, "trace", "", "write parser events to stderr as text or json")
+	flag.StringVar(&explainFormat, "explain", "", "print how the value was reached as a tree or list before it")
+	flag.BoolVar(&collapse, "collapse", false, "leave NULL productions out of -tree and merge single-child chains")
 	flag.Parse()
 
 	if expr == "" {
@@ -23,13 +44,6 @@ func main() {
 		return
 	}
 
-	varDict := formula.VariableDict{
-		"pi":  math.Pi,
-		"e":   math.E,
-		"nan": math.NaN(),
-		"inf": math.Inf(1),
-	}
-
 	lexer := formula.NewLexer()
 	grammar := formula.NewGrammar()
 
@@ -41,16 +55,63 @@ func main() {
 		panic(err)
 	}
 
-	tree, err := rdparser.Compile(tokens, grammar)
+	opts := []rdparser.Option{rdparser.WithRecovery(), rdparser.WithMemoization()}
+	switch traceFormat {
+	case "":
+	case "text":
+		opts = append(opts, rdparser.WithTracer(rdparser.NewTextTracer(os.Stderr)))
+	case "json":
+		opts = append(opts, rdparser.WithTracer(rdparser.NewJSONTracer(os.Stderr)))
+	default:
+		panic(fmt.Sprintf("unknown trace format %q", traceFormat))
+	}
+
+	tree, err := rdparser.Compile(tokens, grammar, opts...)
 	if err != nil {
 		panic(err)
 	}
 
-	rslt, err := parser.Parse(context.Background(), tree)
+	if treeFormat != "" {
+		exportOpts := []rdparser.ExportOption{}
+		if collapse {
+			exportOpts = append(exportOpts, rdparser.CollapseNull(), rdparser.CollapseChains())
+		}
+
+		switch treeFormat {
+		case "dot":
+			fmt.Print(tree.DOT(exportOpts...))
+		case "mermaid":
+			fmt.Print(tree.Mermaid(exportOpts...))
+		default:
+			panic(fmt.Sprintf("unknown tree format %q", treeFormat))
+		}
+		return
+	}
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	ctx, explanation := formula.Explain(ctx)
+
+	rslt, err := parser.Parse(ctx, tree)
 	if err != nil {
 		panic(err)
 	}
 
+	switch explainFormat {
+	case "":
+	case "tree":
+		fmt.Print(explanation.Tree())
+	case "list":
+		fmt.Print(explanation.List())
+	default:
+		panic(fmt.Sprintf("unknown explain format %q", explainFormat))
+	}
+
 	n := rslt.(float64)
 	fmt.Printf("%v\n", n)
 }
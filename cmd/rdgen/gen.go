@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+	"unicode"
+
+	"github.com/michaelrk02/rdparser"
+)
+
+type generator struct {
+	grammar   *rdparser.EBNF
+	pkg       string
+	typ       string
+	symImport string
+	patterns  map[string]string
+}
+
+// checkNames fails if two rules map to the same Go identifier, or one maps to
+// a method or pattern field of the generated grammar type.
+func (gen *generator) checkNames() error {
+	taken := map[string]string{"BuildParseTree": "a generated method"}
+	for _, class := range gen.grammar.Classes() {
+		taken[patternField(class)] = fmt.Sprintf("the pattern field of <%s>", class)
+	}
+
+	for _, r := range gen.grammar.Rules {
+		name := goName(string(r.Name))
+		if name == "" || !unicode.IsLetter([]rune(name)[0]) {
+			return fmt.Errorf("rule `%s` has no valid Go name", r.Name)
+		}
+		if other, ok := taken[name]; ok {
+			return fmt.Errorf("rule `%s` and %s are both named %s in Go", r.Name, other, name)
+		}
+		taken[name] = fmt.Sprintf("rule `%s`", r.Name)
+	}
+	return nil
+}
+
+func (gen *generator) symbolFile() ([]byte, error) {
+	if err := gen.checkNames(); err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+
+	fmt.Fprintf(buf, "// Code generated by rdgen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(buf, "package symbol\n\n")
+	fmt.Fprintf(buf, "import \"github.com/michaelrk02/rdparser\"\n\n")
+
+	fmt.Fprintf(buf, "const (\n")
+	for _, r := range gen.grammar.Rules {
+		fmt.Fprintf(buf, "%s rdparser.NonTerminal = %q\n", goName(string(r.Name)), r.Name)
+	}
+	fmt.Fprintf(buf, ")\n")
+
+	return format.Source(buf.Bytes())
+}
+
+func (gen *generator) grammarFile() ([]byte, error) {
+	if err := gen.checkNames(); err != nil {
+		return nil, err
+	}
+
+	classes := gen.grammar.Classes()
+	for _, class := range classes {
+		if _, ok := gen.patterns[class]; !ok {
+			return nil, fmt.Errorf("missing -pattern for token class <%s>", class)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+
+	fmt.Fprintf(buf, "// Code generated by rdgen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(buf, "package %s\n\n", gen.pkg)
+
+	fmt.Fprintf(buf, "import (\n")
+	fmt.Fprintf(buf, "\"context\"\n")
+	if len(classes) > 0 {
+		fmt.Fprintf(buf, "\"regexp\"\n")
+	}
+	fmt.Fprintf(buf, "\n\"github.com/michaelrk02/rdparser\"\n")
+	fmt.Fprintf(buf, "%q\n", gen.symImport)
+	fmt.Fprintf(buf, ")\n\n")
+
+	fmt.Fprintf(buf, "/*\n\tGrammar:\n\n%s*/\n\n", grammarComment(gen.grammar))
+
+	fmt.Fprintf(buf, "type %s struct {\n", gen.typ)
+	for _, class := range classes {
+		fmt.Fprintf(buf, "%s *regexp.Regexp\n", patternField(class))
+	}
+	fmt.Fprintf(buf, "}\n\n")
+
+	fmt.Fprintf(buf, "func New%s() *%s {\n", gen.typ, gen.typ)
+	fmt.Fprintf(buf, "return &%s{\n", gen.typ)
+	for _, class := range classes {
+		fmt.Fprintf(buf, "%s: regexp.MustCompile(%q),\n", patternField(class), "^"+gen.patterns[class]+"$")
+	}
+	fmt.Fprintf(buf, "}\n}\n\n")
+
+	fmt.Fprintf(buf, "func (g *%s) BuildParseTree(ctx context.Context, b *rdparser.Builder) (err error) {\n", gen.typ)
+	fmt.Fprintf(buf, "defer rdparser.Catch(rdparser.ErrCompile, &err)\n\n")
+	fmt.Fprintf(buf, "ok := g.%s(ctx, b)\n", goName(string(gen.grammar.Start)))
+	fmt.Fprintf(buf, "if !ok {\nerr = rdparser.NewSyntaxError(ctx, b)\nreturn\n}\n\nreturn\n}\n\n")
+
+	for _, r := range gen.grammar.Rules {
+		gen.rule(buf, r)
+	}
+
+	if len(classes) > 0 {
+		fmt.Fprintf(buf, "func (g *%s) matchPattern(b *rdparser.Builder, pattern *regexp.Regexp) bool {\n", gen.typ)
+		fmt.Fprintf(buf, "tok, ok := b.Peek(1)\nif !ok {\nreturn false\n}\n\n")
+		fmt.Fprintf(buf, "sym, _ := rdparser.TerminalOf(tok)\nif !pattern.MatchString(sym.String()) {\nreturn false\n}\n\n")
+		fmt.Fprintf(buf, "b.Next()\nb.Add(tok)\nreturn true\n}\n")
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%w\n%s", err, buf.Bytes())
+	}
+	return src, nil
+}
+
+func (gen *generator) rule(buf *bytes.Buffer, r *rdparser.Rule) {
+	name := goName(string(r.Name))
+
+	fmt.Fprintf(buf, "func (g *%s) %s(ctx context.Context, b *rdparser.Builder) (ok bool) {\n", gen.typ, name)
+	fmt.Fprintf(buf, "defer b.Enter(&ctx, symbol.%s).Exit(&ok)\n", name)
+	fmt.Fprintf(buf, "if b.Recall(&ok) {\nreturn ok\n}\n\n")
+
+	if gen.grammar.IsLeftRecursive(r.Name) {
+		fmt.Fprintf(buf, "return b.Grow(func() bool {\n%s})\n", gen.body(r.Body))
+	} else {
+		buf.WriteString(gen.body(r.Body))
+	}
+
+	fmt.Fprintf(buf, "}\n\n")
+}
+
+func (gen *generator) body(e *rdparser.Expr) string {
+	if e.Kind != rdparser.ExprAlt {
+		return fmt.Sprintf("return %s\n", gen.expr(e))
+	}
+
+	s := ""
+	for _, alt := range e.Items[:len(e.Items)-1] {
+		s += fmt.Sprintf("if %s {\nreturn true\n}\nb.Backtrack()\n\n", gen.expr(alt))
+	}
+	return s + fmt.Sprintf("return %s\n", gen.expr(e.Items[len(e.Items)-1]))
+}
+
+func (gen *generator) expr(e *rdparser.Expr) string {
+	switch e.Kind {
+	case rdparser.ExprNull:
+		return "true"
+
+	case rdparser.ExprLiteral:
+		return fmt.Sprintf("b.Match(rdparser.Terminal(%q))", e.Name)
+
+	case rdparser.ExprClass:
+		return fmt.Sprintf("g.matchPattern(b, g.%s)", patternField(e.Name))
+
+	case rdparser.ExprRef:
+		return fmt.Sprintf("g.%s(ctx, b)", goName(e.Name))
+
+	case rdparser.ExprSeq:
+		items := make([]string, len(e.Items))
+		for i, item := range e.Items {
+			items[i] = gen.expr(item)
+		}
+		return strings.Join(items, " && ")
+
+	case rdparser.ExprAlt:
+		alts := make([]string, len(e.Items))
+		for i, item := range e.Items {
+			alts[i] = gen.closure(item)
+		}
+		return fmt.Sprintf("b.Choice(%s)", strings.Join(alts, ", "))
+
+	case rdparser.ExprOptional:
+		return fmt.Sprintf("b.Optional(%s)", gen.closure(e.Items[0]))
+
+	case rdparser.ExprRepeat:
+		return fmt.Sprintf("b.Repeat(%s)", gen.closure(e.Items[0]))
+	}
+
+	panic(fmt.Sprintf("invalid expression kind %d", e.Kind))
+}
+
+func (gen *generator) closure(e *rdparser.Expr) string {
+	return fmt.Sprintf("func() bool {\nreturn %s\n}", gen.expr(e))
+}
+
+func grammarComment(g *rdparser.EBNF) string {
+	width := 0
+	for _, r := range g.Rules {
+		width = max(width, len(r.Name))
+	}
+	col := (width/8 + 1) * 8
+
+	s := ""
+	for _, r := range g.Rules {
+		tabs := (col - len(r.Name) + 7) / 8
+		s += fmt.Sprintf("\t%s%s-> %s\n", r.Name, strings.Repeat("\t", tabs), r.Body)
+	}
+	return s
+}
+
+// goName turns a rule name into an exported identifier the way the formula
+// symbols are named, e.g. `Expr'` becomes `Exprx`.
+func goName(name string) string {
+	s := []rune{}
+	for _, r := range name {
+		switch {
+		case r == '\'':
+			s = append(s, 'x')
+		case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			s = append(s, r)
+		}
+	}
+	if len(s) > 0 {
+		s[0] = unicode.ToUpper(s[0])
+	}
+	return string(s)
+}
+
+// patternField names the field holding the pattern of a token class, e.g.
+// `<func_name>` becomes `FuncNamePattern`.
+func patternField(class string) string {
+	s := ""
+	for _, part := range strings.FieldsFunc(class, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		s += goName(part)
+	}
+	return s + "Pattern"
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/michaelrk02/rdparser"
+	generated "github.com/michaelrk02/rdparser/cmd/rdgen/testdata/formula"
+	"github.com/michaelrk02/rdparser/pkg/formula"
+	"github.com/michaelrk02/rdparser/pkg/formula/pattern"
+)
+
+var update = flag.Bool("update", false, "rewrite the generated files in testdata")
+
+const (
+	GrammarFile = "../../pkg/formula/grammar.ebnf"
+	OutDir      = "testdata/formula"
+	ImportPath  = "github.com/michaelrk02/rdparser/cmd/rdgen/testdata/formula"
+)
+
+// TestGenerate compares the code generated from the formula grammar with
+// testdata/formula and makes sure it compiles.
+func TestGenerate(t *testing.T) {
+	src, err := os.ReadFile(GrammarFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gen := &generator{
+		grammar:   rdparser.MustParseEBNF(string(src)),
+		pkg:       "formula",
+		typ:       "Grammar",
+		symImport: ImportPath + "/symbol",
+		patterns: map[string]string{
+			"function": pattern.Function,
+			"variable": pattern.Variable,
+			"number":   pattern.Number,
+		},
+	}
+
+	grammarSrc, err := gen.grammarFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	symbolSrc, err := gen.symbolFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, actual := range map[string][]byte{
+		filepath.Join(OutDir, "grammar.go"):          grammarSrc,
+		filepath.Join(OutDir, "symbol", "symbol.go"): symbolSrc,
+	} {
+		if *update {
+			if err := os.WriteFile(path, actual, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		expected, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected, actual) {
+			t.Errorf("%s is out of date, rerun with -update:\n%s", path, actual)
+		}
+	}
+
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+	cmd := exec.Command(goTool, "vet", "./"+OutDir+"/...")
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=readonly")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("generated code does not compile: %v\n%s", err, out)
+	}
+}
+
+// TestGeneratedGrammar runs the generated parser and compares its trees with
+// those of the hand-written formula.Grammar.
+func TestGeneratedGrammar(t *testing.T) {
+	exprs := []string{
+		"1 + 2 * 3 - 4 / 5 mod 6",
+		"-(1 - -[a])",
+		"max() + max([x], pow(2, 3), sum(1, ))",
+		"((1 > 0 && (2 < 3 || not (4 == 4))) ? 1 : 2)",
+		"([a] <> 1 or ~[b] >= 2 and [c] ~= 3 ? 4 : 5)",
+		"1 +",
+		"max(1",
+		"(1 > 2)",
+	}
+
+	for _, expr := range exprs {
+		tokens, err := formula.NewLexer().Lex(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected, expectedErr := rdparser.Compile(tokens, formula.NewGrammar())
+		actual, actualErr := rdparser.Compile(tokens, generated.NewGrammar())
+		if (expectedErr == nil) != (actualErr == nil) {
+			t.Errorf("%s: expected error %v, got %v", expr, expectedErr, actualErr)
+			continue
+		}
+
+		if expectedErr == nil && expected.String() != actual.String() {
+			t.Errorf("%s: tree differs\n%s\n%s", expr, expected, actual)
+		}
+	}
+}
+
+func TestNameCollisions(t *testing.T) {
+	for src, expected := range map[string]string{
+		"Exprx -> \"a\"\nExpr' -> \"b\"":                "rule `Expr'` and rule `Exprx` are both named Exprx",
+		"BuildParseTree -> \"a\"":                       "rule `BuildParseTree` and a generated method",
+		"S -> NumberPattern\nNumberPattern -> <number>": "rule `NumberPattern` and the pattern field of <number>",
+	} {
+		gen := &generator{grammar: rdparser.MustParseEBNF(src), patterns: map[string]string{"number": "[0-9]+"}}
+		if _, err := gen.grammarFile(); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%q: expected %q, got %v", src, expected, err)
+		}
+	}
+}
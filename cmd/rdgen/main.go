@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/michaelrk02/rdparser"
+)
+
+type patternFlag map[string]string
+
+func (f patternFlag) String() string {
+	return fmt.Sprint(map[string]string(f))
+}
+
+func (f patternFlag) Set(s string) error {
+	name, pattern, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expecting name=regexp, got %q", s)
+	}
+	f[name] = pattern
+	return nil
+}
+
+func main() {
+	var out, pkg, typ, importPath string
+	var analyze bool
+	patterns := patternFlag{}
+
+	flag.StringVar(&out, "out", ".", "write grammar.go and symbol/symbol.go into this directory")
+	flag.StringVar(&pkg, "package", "", "package name of the generated grammar (default: base name of -out)")
+	flag.StringVar(&typ, "type", "Grammar", "name of the generated grammar type")
+	flag.StringVar(&importPath, "import", "", "import path of the -out directory, used to import its symbol package")
+	flag.BoolVar(&analyze, "analyze", false, "print FIRST/FOLLOW sets, LL(1) conflicts and dead or left-recursive rules instead of generating code")
+	flag.Var(patterns, "pattern", "regexp of a token class as name=regexp, e.g. number=[0-9]+ (repeatable)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: rdgen [flags] grammar.ebnf\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 || (importPath == "" && !analyze) {
+		flag.Usage()
+		return
+	}
+
+	src, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		panic(err)
+	}
+
+	grammar, err := rdparser.ParseEBNF(string(src))
+	if err != nil {
+		panic(err)
+	}
+
+	if analyze {
+		a := rdparser.Analyze(grammar)
+		fmt.Print(a)
+		if !a.OK() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if pkg == "" {
+		abs, err := filepath.Abs(out)
+		if err != nil {
+			panic(err)
+		}
+		pkg = filepath.Base(abs)
+	}
+
+	gen := &generator{
+		grammar:   grammar,
+		pkg:       pkg,
+		typ:       typ,
+		symImport: importPath + "/symbol",
+		patterns:  patterns,
+	}
+
+	grammarSrc, err := gen.grammarFile()
+	if err != nil {
+		panic(err)
+	}
+
+	symbolSrc, err := gen.symbolFile()
+	if err != nil {
+		panic(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(out, "symbol"), 0755); err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(filepath.Join(out, "grammar.go"), grammarSrc, 0644); err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(filepath.Join(out, "symbol", "symbol.go"), symbolSrc, 0644); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,423 @@
+// Code generated by rdgen. DO NOT EDIT.
+
+package formula
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/michaelrk02/rdparser"
+	"github.com/michaelrk02/rdparser/cmd/rdgen/testdata/formula/symbol"
+)
+
+/*
+	Grammar:
+
+	Expr		-> Term Expr'
+	Expr'		-> "+" Expr | "-" Expr | NULL
+	Term		-> Factor Term'
+	Term'		-> "*" Term | "/" Term | "mod" Term | NULL
+	Factor		-> "(" Factor' | "-" Factor | Variable | Number | FuncCall
+	Factor'		-> BoolCond ")" | Expr ")"
+	FuncCall	-> FuncName "(" FuncArg ")"
+	FuncName	-> <function>
+	FuncArg		-> Expr FuncArg' | NULL
+	FuncArg'	-> "," FuncArg | NULL
+	BoolCond	-> BoolExpr "?" Expr ":" Expr
+	BoolExpr	-> BoolTerm BoolExpr'
+	BoolExpr'	-> LogicOr BoolExpr | NULL
+	BoolTerm	-> BoolFactor BoolTerm'
+	BoolTerm'	-> LogicAnd BoolTerm | NULL
+	BoolFactor	-> LogicNot BoolFactor | "(" BoolExpr ")" | LogicExpr
+	LogicExpr	-> Expr LogicOp Expr
+	LogicOr		-> "||" | "or"
+	LogicAnd	-> "&&" | "and"
+	LogicNot	-> "!" | "~" | "not"
+	LogicOp		-> "==" | "!=" | "~=" | "<>" | "<=" | ">=" | "<" | ">"
+	Variable	-> <variable>
+	Number		-> <number>
+*/
+
+type Grammar struct {
+	FunctionPattern *regexp.Regexp
+	NumberPattern   *regexp.Regexp
+	VariablePattern *regexp.Regexp
+}
+
+func NewGrammar() *Grammar {
+	return &Grammar{
+		FunctionPattern: regexp.MustCompile("^([a-zA-Z][a-zA-Z0-9]*)$"),
+		NumberPattern:   regexp.MustCompile("^([0-9]+(\\.[0-9]+)?(e[\\+-][0-9]+)?)$"),
+		VariablePattern: regexp.MustCompile("^\\[([a-zA-Z0-9-:]+)\\]$"),
+	}
+}
+
+func (g *Grammar) BuildParseTree(ctx context.Context, b *rdparser.Builder) (err error) {
+	defer rdparser.Catch(rdparser.ErrCompile, &err)
+
+	ok := g.Expr(ctx, b)
+	if !ok {
+		err = rdparser.NewSyntaxError(ctx, b)
+		return
+	}
+
+	return
+}
+
+func (g *Grammar) Expr(ctx context.Context, b *rdparser.Builder) (ok bool) {
+	defer b.Enter(&ctx, symbol.Expr).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	return g.Term(ctx, b) && g.Exprx(ctx, b)
+}
+
+func (g *Grammar) Exprx(ctx context.Context, b *rdparser.Builder) (ok bool) {
+	defer b.Enter(&ctx, symbol.Exprx).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	if b.Match(rdparser.Terminal("+")) && g.Expr(ctx, b) {
+		return true
+	}
+	b.Backtrack()
+
+	if b.Match(rdparser.Terminal("-")) && g.Expr(ctx, b) {
+		return true
+	}
+	b.Backtrack()
+
+	return true
+}
+
+func (g *Grammar) Term(ctx context.Context, b *rdparser.Builder) (ok bool) {
+	defer b.Enter(&ctx, symbol.Term).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	return g.Factor(ctx, b) && g.Termx(ctx, b)
+}
+
+func (g *Grammar) Termx(ctx context.Context, b *rdparser.Builder) (ok bool) {
+	defer b.Enter(&ctx, symbol.Termx).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	if b.Match(rdparser.Terminal("*")) && g.Term(ctx, b) {
+		return true
+	}
+	b.Backtrack()
+
+	if b.Match(rdparser.Terminal("/")) && g.Term(ctx, b) {
+		return true
+	}
+	b.Backtrack()
+
+	if b.Match(rdparser.Terminal("mod")) && g.Term(ctx, b) {
+		return true
+	}
+	b.Backtrack()
+
+	return true
+}
+
+func (g *Grammar) Factor(ctx context.Context, b *rdparser.Builder) (ok bool) {
+	defer b.Enter(&ctx, symbol.Factor).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	if b.Match(rdparser.Terminal("(")) && g.Factorx(ctx, b) {
+		return true
+	}
+	b.Backtrack()
+
+	if b.Match(rdparser.Terminal("-")) && g.Factor(ctx, b) {
+		return true
+	}
+	b.Backtrack()
+
+	if g.Variable(ctx, b) {
+		return true
+	}
+	b.Backtrack()
+
+	if g.Number(ctx, b) {
+		return true
+	}
+	b.Backtrack()
+
+	return g.FuncCall(ctx, b)
+}
+
+func (g *Grammar) Factorx(ctx context.Context, b *rdparser.Builder) (ok bool) {
+	defer b.Enter(&ctx, symbol.Factorx).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	if g.BoolCond(ctx, b) && b.Match(rdparser.Terminal(")")) {
+		return true
+	}
+	b.Backtrack()
+
+	return g.Expr(ctx, b) && b.Match(rdparser.Terminal(")"))
+}
+
+func (g *Grammar) FuncCall(ctx context.Context, b *rdparser.Builder) (ok bool) {
+	defer b.Enter(&ctx, symbol.FuncCall).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	return g.FuncName(ctx, b) && b.Match(rdparser.Terminal("(")) && g.FuncArg(ctx, b) && b.Match(rdparser.Terminal(")"))
+}
+
+func (g *Grammar) FuncName(ctx context.Context, b *rdparser.Builder) (ok bool) {
+	defer b.Enter(&ctx, symbol.FuncName).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	return g.matchPattern(b, g.FunctionPattern)
+}
+
+func (g *Grammar) FuncArg(ctx context.Context, b *rdparser.Builder) (ok bool) {
+	defer b.Enter(&ctx, symbol.FuncArg).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	if g.Expr(ctx, b) && g.FuncArgx(ctx, b) {
+		return true
+	}
+	b.Backtrack()
+
+	return true
+}
+
+func (g *Grammar) FuncArgx(ctx context.Context, b *rdparser.Builder) (ok bool) {
+	defer b.Enter(&ctx, symbol.FuncArgx).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	if b.Match(rdparser.Terminal(",")) && g.FuncArg(ctx, b) {
+		return true
+	}
+	b.Backtrack()
+
+	return true
+}
+
+func (g *Grammar) BoolCond(ctx context.Context, b *rdparser.Builder) (ok bool) {
+	defer b.Enter(&ctx, symbol.BoolCond).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	return g.BoolExpr(ctx, b) && b.Match(rdparser.Terminal("?")) && g.Expr(ctx, b) && b.Match(rdparser.Terminal(":")) && g.Expr(ctx, b)
+}
+
+func (g *Grammar) BoolExpr(ctx context.Context, b *rdparser.Builder) (ok bool) {
+	defer b.Enter(&ctx, symbol.BoolExpr).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	return g.BoolTerm(ctx, b) && g.BoolExprx(ctx, b)
+}
+
+func (g *Grammar) BoolExprx(ctx context.Context, b *rdparser.Builder) (ok bool) {
+	defer b.Enter(&ctx, symbol.BoolExprx).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	if g.LogicOr(ctx, b) && g.BoolExpr(ctx, b) {
+		return true
+	}
+	b.Backtrack()
+
+	return true
+}
+
+func (g *Grammar) BoolTerm(ctx context.Context, b *rdparser.Builder) (ok bool) {
+	defer b.Enter(&ctx, symbol.BoolTerm).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	return g.BoolFactor(ctx, b) && g.BoolTermx(ctx, b)
+}
+
+func (g *Grammar) BoolTermx(ctx context.Context, b *rdparser.Builder) (ok bool) {
+	defer b.Enter(&ctx, symbol.BoolTermx).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	if g.LogicAnd(ctx, b) && g.BoolTerm(ctx, b) {
+		return true
+	}
+	b.Backtrack()
+
+	return true
+}
+
+func (g *Grammar) BoolFactor(ctx context.Context, b *rdparser.Builder) (ok bool) {
+	defer b.Enter(&ctx, symbol.BoolFactor).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	if g.LogicNot(ctx, b) && g.BoolFactor(ctx, b) {
+		return true
+	}
+	b.Backtrack()
+
+	if b.Match(rdparser.Terminal("(")) && g.BoolExpr(ctx, b) && b.Match(rdparser.Terminal(")")) {
+		return true
+	}
+	b.Backtrack()
+
+	return g.LogicExpr(ctx, b)
+}
+
+func (g *Grammar) LogicExpr(ctx context.Context, b *rdparser.Builder) (ok bool) {
+	defer b.Enter(&ctx, symbol.LogicExpr).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	return g.Expr(ctx, b) && g.LogicOp(ctx, b) && g.Expr(ctx, b)
+}
+
+func (g *Grammar) LogicOr(ctx context.Context, b *rdparser.Builder) (ok bool) {
+	defer b.Enter(&ctx, symbol.LogicOr).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	if b.Match(rdparser.Terminal("||")) {
+		return true
+	}
+	b.Backtrack()
+
+	return b.Match(rdparser.Terminal("or"))
+}
+
+func (g *Grammar) LogicAnd(ctx context.Context, b *rdparser.Builder) (ok bool) {
+	defer b.Enter(&ctx, symbol.LogicAnd).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	if b.Match(rdparser.Terminal("&&")) {
+		return true
+	}
+	b.Backtrack()
+
+	return b.Match(rdparser.Terminal("and"))
+}
+
+func (g *Grammar) LogicNot(ctx context.Context, b *rdparser.Builder) (ok bool) {
+	defer b.Enter(&ctx, symbol.LogicNot).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	if b.Match(rdparser.Terminal("!")) {
+		return true
+	}
+	b.Backtrack()
+
+	if b.Match(rdparser.Terminal("~")) {
+		return true
+	}
+	b.Backtrack()
+
+	return b.Match(rdparser.Terminal("not"))
+}
+
+func (g *Grammar) LogicOp(ctx context.Context, b *rdparser.Builder) (ok bool) {
+	defer b.Enter(&ctx, symbol.LogicOp).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	if b.Match(rdparser.Terminal("==")) {
+		return true
+	}
+	b.Backtrack()
+
+	if b.Match(rdparser.Terminal("!=")) {
+		return true
+	}
+	b.Backtrack()
+
+	if b.Match(rdparser.Terminal("~=")) {
+		return true
+	}
+	b.Backtrack()
+
+	if b.Match(rdparser.Terminal("<>")) {
+		return true
+	}
+	b.Backtrack()
+
+	if b.Match(rdparser.Terminal("<=")) {
+		return true
+	}
+	b.Backtrack()
+
+	if b.Match(rdparser.Terminal(">=")) {
+		return true
+	}
+	b.Backtrack()
+
+	if b.Match(rdparser.Terminal("<")) {
+		return true
+	}
+	b.Backtrack()
+
+	return b.Match(rdparser.Terminal(">"))
+}
+
+func (g *Grammar) Variable(ctx context.Context, b *rdparser.Builder) (ok bool) {
+	defer b.Enter(&ctx, symbol.Variable).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	return g.matchPattern(b, g.VariablePattern)
+}
+
+func (g *Grammar) Number(ctx context.Context, b *rdparser.Builder) (ok bool) {
+	defer b.Enter(&ctx, symbol.Number).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	return g.matchPattern(b, g.NumberPattern)
+}
+
+func (g *Grammar) matchPattern(b *rdparser.Builder, pattern *regexp.Regexp) bool {
+	tok, ok := b.Peek(1)
+	if !ok {
+		return false
+	}
+
+	sym, _ := rdparser.TerminalOf(tok)
+	if !pattern.MatchString(sym.String()) {
+		return false
+	}
+
+	b.Next()
+	b.Add(tok)
+	return true
+}
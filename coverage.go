@@ -0,0 +1,409 @@
+package rdparser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/shivamMg/rd"
+)
+
+// Coverage counts, across any number of Compile calls, how often each
+// non-terminal was entered and matched, which productions the compiled trees
+// used and which terminals they contain. When WithRecovery compiles an input
+// twice, only the pass whose result Compile returns is counted. It is safe for
+// concurrent use.
+//
+// Productions are read off the returned trees. Nodes that hold an <error>
+// node are left out, as is the content of the <error> nodes themselves, and
+// with WithAnnotations no productions are recorded at all, since Inline,
+// Hidden and List nodes no longer show what was matched; their alternatives
+// then appear untested.
+type Coverage struct {
+	mu          sync.Mutex
+	entered     map[NonTerminal]int
+	matched     map[NonTerminal]int
+	terminals   map[Terminal]int
+	productions map[string]*production
+}
+
+// production is a non-terminal together with the symbols of its children,
+// each a NonTerminal or a Terminal.
+type production struct {
+	sym     NonTerminal
+	symbols []interface{}
+	count   int
+}
+
+func NewCoverage() *Coverage {
+	return &Coverage{
+		entered:     make(map[NonTerminal]int),
+		matched:     make(map[NonTerminal]int),
+		terminals:   make(map[Terminal]int),
+		productions: make(map[string]*production),
+	}
+}
+
+func WithCoverage(c *Coverage) Option {
+	return func(o *options) {
+		o.coverage = c
+	}
+}
+
+func (c *Coverage) Entered(sym NonTerminal) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entered[sym]
+}
+
+func (c *Coverage) Matched(sym NonTerminal) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.matched[sym]
+}
+
+func (c *Coverage) Terminal(sym Terminal) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.terminals[sym]
+}
+
+func (c *Coverage) event(e Event) {
+	sym, ok := e.Symbol.(NonTerminal)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case e.Kind == EventEnter:
+		c.entered[sym]++
+	case e.Kind == EventExit && e.OK:
+		c.matched[sym]++
+	}
+}
+
+func (c *Coverage) merge(other *Coverage) {
+	if c == nil || other == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for sym, n := range other.entered {
+		c.entered[sym] += n
+	}
+	for sym, n := range other.matched {
+		c.matched[sym] += n
+	}
+	for sym, n := range other.terminals {
+		c.terminals[sym] += n
+	}
+	for key, p := range other.productions {
+		if prev, ok := c.productions[key]; ok {
+			prev.count += p.count
+		} else {
+			c.productions[key] = &production{sym: p.sym, symbols: p.symbols, count: p.count}
+		}
+	}
+}
+
+// addTree counts the terminals of t and, unless it was shaped by annotations,
+// its productions.
+func (c *Coverage) addTree(t *Tree, shaped bool) {
+	if c == nil || t == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var add func(t *rd.Tree)
+	add = func(t *rd.Tree) {
+		if sym, ok := TerminalOf(t.Symbol); ok {
+			c.terminals[sym]++
+			return
+		}
+
+		sym, _ := t.Symbol.(NonTerminal)
+		if sym == ErrorSymbol {
+			return
+		}
+
+		p := &production{sym: sym}
+		recovered := false
+		for _, sub := range t.Subtrees {
+			if term, ok := TerminalOf(sub.Symbol); ok {
+				p.symbols = append(p.symbols, term)
+			} else {
+				p.symbols = append(p.symbols, sub.Symbol)
+				recovered = recovered || sub.Symbol == ErrorSymbol
+			}
+			add(sub)
+		}
+		if shaped || recovered {
+			return
+		}
+
+		key := p.String()
+		if prev, ok := c.productions[key]; ok {
+			p = prev
+		} else {
+			c.productions[key] = p
+		}
+		p.count++
+	}
+	add(t.Tree)
+}
+
+func (p *production) String() string {
+	parts := []string{}
+	for _, sym := range p.symbols {
+		if term, ok := sym.(Terminal); ok {
+			parts = append(parts, fmt.Sprintf("%q", term))
+		} else {
+			parts = append(parts, fmt.Sprint(sym))
+		}
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "NULL")
+	}
+	return fmt.Sprintf("%s -> %s", p.sym, strings.Join(parts, " "))
+}
+
+type CoverageReport struct {
+	Rules     []*RuleCoverage
+	Terminals []*TerminalCoverage
+}
+
+type RuleCoverage struct {
+	Rule         *Rule
+	Entered      int
+	Matched      int
+	Alternatives []*AlternativeCoverage
+}
+
+// AlternativeCoverage counts the tree nodes built by one top-level
+// alternative of a rule.
+type AlternativeCoverage struct {
+	Expr  *Expr
+	Count int
+}
+
+type TerminalCoverage struct {
+	Terminal Terminal
+	Count    int
+}
+
+// Report matches the recorded productions against the alternatives of every
+// rule of g, a production counting towards the first alternative it fits.
+// Terminals default to the literals of g.
+func (c *Coverage) Report(g *EBNF, terminals ...Terminal) *CoverageReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(terminals) == 0 {
+		for _, r := range g.Rules {
+			walkExpr(r.Body, func(e *Expr) {
+				if e.Kind == ExprLiteral {
+					terminals = append(terminals, Terminal(e.Name))
+				}
+			})
+		}
+	}
+
+	report := &CoverageReport{}
+
+	for _, r := range g.Rules {
+		rc := &RuleCoverage{Rule: r, Entered: c.entered[r.Name], Matched: c.matched[r.Name]}
+
+		alts := []*Expr{r.Body}
+		if r.Body.Kind == ExprAlt {
+			alts = r.Body.Items
+		}
+		for _, alt := range alts {
+			rc.Alternatives = append(rc.Alternatives, &AlternativeCoverage{Expr: alt})
+		}
+
+		for _, p := range c.productions {
+			if p.sym != r.Name {
+				continue
+			}
+			for _, ac := range rc.Alternatives {
+				if g.fits(ac.Expr, p.symbols) {
+					ac.Count += p.count
+					break
+				}
+			}
+		}
+
+		report.Rules = append(report.Rules, rc)
+	}
+
+	seen := map[Terminal]bool{}
+	for _, term := range terminals {
+		if !seen[term] {
+			seen[term] = true
+			report.Terminals = append(report.Terminals, &TerminalCoverage{Terminal: term, Count: c.terminals[term]})
+		}
+	}
+	sort.Slice(report.Terminals, func(i, j int) bool {
+		return report.Terminals[i].Terminal < report.Terminals[j].Terminal
+	})
+
+	return report
+}
+
+// Untested lists the alternatives and terminals no compiled tree used, e.g.
+// `LogicNot -> "~"`.
+func (r *CoverageReport) Untested() []string {
+	untested := []string{}
+	for _, rc := range r.Rules {
+		for _, ac := range rc.Alternatives {
+			if ac.Count == 0 {
+				untested = append(untested, fmt.Sprintf("%s -> %s", rc.Rule.Name, ac.Expr))
+			}
+		}
+	}
+	for _, tc := range r.Terminals {
+		if tc.Count == 0 {
+			untested = append(untested, fmt.Sprintf("%q", tc.Terminal))
+		}
+	}
+	return untested
+}
+
+func (r *CoverageReport) String() string {
+	sb := &strings.Builder{}
+
+	alts, altsTested := 0, 0
+	for _, rc := range r.Rules {
+		fmt.Fprintf(sb, "%s (entered %d, matched %d)\n", rc.Rule.Name, rc.Entered, rc.Matched)
+		for _, ac := range rc.Alternatives {
+			alts++
+			mark := ""
+			if ac.Count > 0 {
+				altsTested++
+			} else {
+				mark = "  untested"
+			}
+			fmt.Fprintf(sb, "  %-6d %s%s\n", ac.Count, ac.Expr, mark)
+		}
+	}
+
+	terms, termsTested := 0, 0
+	sb.WriteString("terminals\n")
+	for _, tc := range r.Terminals {
+		terms++
+		mark := ""
+		if tc.Count > 0 {
+			termsTested++
+		} else {
+			mark = "  untested"
+		}
+		fmt.Fprintf(sb, "  %-6d %q%s\n", tc.Count, tc.Terminal, mark)
+	}
+
+	fmt.Fprintf(sb, "%d of %d alternatives and %d of %d terminals tested\n", altsTested, alts, termsTested, terms)
+	return sb.String()
+}
+
+// fits reports whether e can derive exactly symbols.
+func (g *EBNF) fits(e *Expr, symbols []interface{}) bool {
+	for _, end := range g.ends(e, symbols, 0) {
+		if end == len(symbols) {
+			return true
+		}
+	}
+	return false
+}
+
+// ends returns every position at which e can stop when it starts at symbols[i].
+func (g *EBNF) ends(e *Expr, symbols []interface{}, i int) []int {
+	switch e.Kind {
+	case ExprNull:
+		return []int{i}
+
+	case ExprLiteral, ExprClass, ExprRef:
+		if i < len(symbols) && g.fitsSymbol(e, symbols[i]) {
+			return []int{i + 1}
+		}
+		return nil
+
+	case ExprSeq:
+		pos := []int{i}
+		for _, item := range e.Items {
+			next := []int{}
+			for _, j := range pos {
+				next = union(next, g.ends(item, symbols, j))
+			}
+			pos = next
+		}
+		return pos
+
+	case ExprAlt:
+		pos := []int{}
+		for _, item := range e.Items {
+			pos = union(pos, g.ends(item, symbols, i))
+		}
+		return pos
+
+	case ExprOptional:
+		return union([]int{i}, g.ends(e.Items[0], symbols, i))
+
+	case ExprRepeat:
+		pos := []int{i}
+		for frontier := []int{i}; len(frontier) > 0; {
+			next := []int{}
+			for _, j := range frontier {
+				for _, k := range g.ends(e.Items[0], symbols, j) {
+					if !contains(pos, k) {
+						pos = append(pos, k)
+						next = append(next, k)
+					}
+				}
+			}
+			frontier = next
+		}
+		return pos
+	}
+
+	return nil
+}
+
+func (g *EBNF) fitsSymbol(e *Expr, sym interface{}) bool {
+	switch e.Kind {
+	case ExprLiteral:
+		return sym == Terminal(e.Name)
+	case ExprClass:
+		term, ok := sym.(Terminal)
+		if !ok {
+			return false
+		}
+		class, ok := g.classes[e.Name]
+		return !ok || class(term)
+	}
+	return sym == NonTerminal(e.Name)
+}
+
+func union(a, b []int) []int {
+	for _, n := range b {
+		if !contains(a, n) {
+			a = append(a, n)
+		}
+	}
+	return a
+}
+
+func contains(a []int, n int) bool {
+	for _, m := range a {
+		if m == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package rdparser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shivamMg/ppds/tree"
+)
+
+// DebugTree records every non-terminal entered while building, with its
+// result in parentheses, and every terminal it tried to match. It replaces the
+// debug tree of the rd.Builder that Builder used to embed and has the same
+// methods, so callers of Data, Children and String keep working.
+type DebugTree struct {
+	data     string
+	subtrees []*DebugTree
+}
+
+func (dt *DebugTree) Data() interface{} {
+	return dt.data
+}
+
+func (dt *DebugTree) Children() (c []tree.Node) {
+	for _, sub := range dt.subtrees {
+		c = append(c, sub)
+	}
+	return
+}
+
+func (dt *DebugTree) String() string {
+	sb := &strings.Builder{}
+
+	var write func(dt *DebugTree, depth int)
+	write = func(dt *DebugTree, depth int) {
+		fmt.Fprintf(sb, "%s%s\n", strings.Repeat("  ", depth), dt.data)
+		for _, sub := range dt.subtrees {
+			write(sub, depth+1)
+		}
+	}
+	write(dt, 0)
+
+	return sb.String()
+}
+
+// DebugTree returns the debug tree of the start rule once it has exited, nil
+// before.
+func (b *Builder) DebugTree() *DebugTree {
+	if b.debugTree == nil || len(b.debugTree.subtrees) == 0 {
+		return nil
+	}
+	return b.debugTree.subtrees[0]
+}
+
+// Err returns, like rd.Builder.Err, why the start rule failed or the token it
+// left unconsumed, nil on success or before it has exited. Where rd returned a
+// *rd.ParsingError, the *SyntaxError carries the position as well.
+func (b *Builder) Err() *SyntaxError {
+	return b.err
+}
+
+func (b *Builder) startErr(ok bool) *SyntaxError {
+	if !ok {
+		return &SyntaxError{Pos: b.pos(), Msg: "parsing error"}
+	}
+	if tok, ok := b.Peek(1); ok {
+		return &SyntaxError{Pos: PositionOf(tok), Msg: fmt.Sprintf("unexpected token `%s`", tok)}
+	}
+	return nil
+}
+
+func (b *Builder) debugMatch(next, token interface{}, ok bool) {
+	data := fmt.Sprint("<no tokens left> ≠ ", token)
+	switch {
+	case ok:
+		data = fmt.Sprint(next, " = ", token)
+	case next != nil:
+		data = fmt.Sprint(next, " ≠ ", token)
+	}
+	f := b.frame()
+	f.debug.subtrees = append(f.debug.subtrees, &DebugTree{data: data})
+}
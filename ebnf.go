@@ -0,0 +1,588 @@
+package rdparser
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/shivamMg/rd"
+)
+
+var ErrGrammar = fmt.Errorf("grammar error")
+
+type ExprKind int
+
+const (
+	ExprNull ExprKind = iota
+	ExprLiteral
+	ExprClass
+	ExprRef
+	ExprSeq
+	ExprAlt
+	ExprOptional
+	ExprRepeat
+)
+
+// Expr is a node of a rule body. Literal, Class and Ref carry Name; Seq and
+// Alt carry Items; Optional and Repeat carry a single item.
+type Expr struct {
+	Kind  ExprKind
+	Name  string
+	Items []*Expr
+	Pos   Position
+}
+
+func (e *Expr) String() string {
+	switch e.Kind {
+	case ExprNull:
+		return "NULL"
+	case ExprLiteral:
+		return strconv.Quote(e.Name)
+	case ExprClass:
+		return "<" + e.Name + ">"
+	case ExprRef:
+		return e.Name
+	case ExprOptional:
+		return "[ " + e.Items[0].String() + " ]"
+	case ExprRepeat:
+		return "{ " + e.Items[0].String() + " }"
+	}
+
+	sep := " "
+	if e.Kind == ExprAlt {
+		sep = " | "
+	}
+	parts := make([]string, len(e.Items))
+	for i, item := range e.Items {
+		parts[i] = item.String()
+		if item.Kind == ExprAlt || (item.Kind == ExprSeq && e.Kind == ExprSeq) {
+			parts[i] = "( " + parts[i] + " )"
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+type Rule struct {
+	Name NonTerminal
+	Body *Expr
+	Pos  Position
+}
+
+func (r *Rule) String() string {
+	return fmt.Sprintf("%s -> %s", r.Name, r.Body)
+}
+
+// EBNF is a grammar loaded from text in the notation used by the README:
+//
+//	Expr    -> Term { ("+" | "-") Term }
+//	Term    -> Factor Term'
+//	Term'   -> "*" Term | NULL
+//	Factor  -> "(" Expr ")" | [ "-" ] <number>
+//
+// Quoted literals match terminals, <class> matches tokens accepted by the
+// function registered with Class, [ ] is optional, { } repeats zero or more
+// times and ( ) groups. A rule ends where the next `Name ->` begins and the
+// first rule is the start symbol. Alternatives are tried in order, like the
+// hand-written grammars, and directly left-recursive rules are grown with
+// Grow. Every rule becomes a node; groups, options and repetitions are inlined
+// into the node of the rule that uses them.
+type EBNF struct {
+	Start NonTerminal
+	Rules []*Rule
+
+	index   map[NonTerminal]*Rule
+	left    map[NonTerminal]bool
+	classes map[string]func(tok rd.Token) bool
+}
+
+func ParseEBNF(src string) (*EBNF, error) {
+	tokens, err := lexEBNF(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &ebnfParser{tokens: tokens}
+	rules, err := p.grammar()
+	if err != nil {
+		return nil, err
+	}
+
+	g := &EBNF{
+		Rules:   rules,
+		index:   make(map[NonTerminal]*Rule),
+		left:    make(map[NonTerminal]bool),
+		classes: make(map[string]func(tok rd.Token) bool),
+	}
+	if len(rules) > 0 {
+		g.Start = rules[0].Name
+	}
+
+	for _, r := range rules {
+		if _, ok := g.index[r.Name]; ok {
+			return nil, grammarError(r.Pos, fmt.Sprintf("duplicate rule `%s`", r.Name))
+		}
+		g.index[r.Name] = r
+	}
+
+	for _, r := range rules {
+		if err := g.resolve(r.Body); err != nil {
+			return nil, err
+		}
+		g.left[r.Name] = leftRefs(r.Body)[string(r.Name)]
+	}
+
+	return g, nil
+}
+
+func MustParseEBNF(src string) *EBNF {
+	g, err := ParseEBNF(src)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+func (g *EBNF) Class(name string, match func(tok rd.Token) bool) *EBNF {
+	g.classes[name] = match
+	return g
+}
+
+func (g *EBNF) Rule(name NonTerminal) (*Rule, bool) {
+	r, ok := g.index[name]
+	return r, ok
+}
+
+func (g *EBNF) IsLeftRecursive(name NonTerminal) bool {
+	return g.left[name]
+}
+
+// Classes returns the names of the token classes referenced by the rules.
+func (g *EBNF) Classes() []string {
+	seen := map[string]bool{}
+	for _, r := range g.Rules {
+		walkExpr(r.Body, func(e *Expr) {
+			if e.Kind == ExprClass {
+				seen[e.Name] = true
+			}
+		})
+	}
+
+	names := []string{}
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (g *EBNF) String() string {
+	lines := make([]string, len(g.Rules))
+	for i, r := range g.Rules {
+		lines[i] = r.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (g *EBNF) BuildParseTree(ctx context.Context, b *Builder) error {
+	for _, name := range g.Classes() {
+		if _, ok := g.classes[name]; !ok {
+			return NewError(ErrGrammar, fmt.Sprintf("undefined token class `<%s>`", name))
+		}
+	}
+
+	start, ok := g.index[g.Start]
+	if !ok {
+		return NewError(ErrGrammar, fmt.Sprintf("undefined start rule `%s`", g.Start))
+	}
+
+	if !g.rule(ctx, b, start) {
+		return NewSyntaxError(ctx, b)
+	}
+	return nil
+}
+
+func (g *EBNF) rule(ctx context.Context, b *Builder, r *Rule) (ok bool) {
+	defer b.Enter(&ctx, r.Name).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	if g.left[r.Name] {
+		return b.Grow(func() bool {
+			return g.match(ctx, b, r.Body)
+		})
+	}
+	return g.match(ctx, b, r.Body)
+}
+
+func (g *EBNF) match(ctx context.Context, b *Builder, e *Expr) bool {
+	switch e.Kind {
+	case ExprNull:
+		return true
+
+	case ExprLiteral:
+		return b.Match(Terminal(e.Name))
+
+	case ExprClass:
+		tok, ok := b.Peek(1)
+		if !ok || !g.classes[e.Name](tok) {
+			return false
+		}
+		b.Next()
+		b.Add(tok)
+		return true
+
+	case ExprRef:
+		return g.rule(ctx, b, g.index[NonTerminal(e.Name)])
+
+	case ExprSeq:
+		for _, item := range e.Items {
+			if !g.match(ctx, b, item) {
+				return false
+			}
+		}
+		return true
+
+	case ExprAlt:
+		alts := make([]func() bool, len(e.Items))
+		for i, item := range e.Items {
+			alts[i] = func() bool { return g.match(ctx, b, item) }
+		}
+		return b.Choice(alts...)
+
+	case ExprOptional:
+		return b.Optional(func() bool { return g.match(ctx, b, e.Items[0]) })
+
+	case ExprRepeat:
+		return b.Repeat(func() bool { return g.match(ctx, b, e.Items[0]) })
+	}
+
+	panic(fmt.Sprintf("invalid expression kind %d", e.Kind))
+}
+
+func (g *EBNF) resolve(e *Expr) (err error) {
+	walkExpr(e, func(e *Expr) {
+		if err == nil && e.Kind == ExprRef {
+			if _, ok := g.index[NonTerminal(e.Name)]; !ok {
+				err = grammarError(e.Pos, fmt.Sprintf("undefined rule `%s`", e.Name))
+			}
+		}
+	})
+	return
+}
+
+func walkExpr(e *Expr, fn func(e *Expr)) {
+	fn(e)
+	for _, item := range e.Items {
+		walkExpr(item, fn)
+	}
+}
+
+// leftRefs returns the rules that may be entered at the start of e without
+// consuming any input from e first.
+func leftRefs(e *Expr) map[string]bool {
+	refs := map[string]bool{}
+
+	var visit func(e *Expr) bool
+	visit = func(e *Expr) (nullable bool) {
+		switch e.Kind {
+		case ExprNull:
+			return true
+		case ExprRef:
+			refs[e.Name] = true
+			return false
+		case ExprSeq:
+			for _, item := range e.Items {
+				if !visit(item) {
+					return false
+				}
+			}
+			return true
+		case ExprAlt:
+			for _, item := range e.Items {
+				if visit(item) {
+					nullable = true
+				}
+			}
+			return nullable
+		case ExprOptional, ExprRepeat:
+			visit(e.Items[0])
+			return true
+		}
+		return false
+	}
+
+	visit(e)
+	return refs
+}
+
+func grammarError(pos Position, msg string) error {
+	return NewError(ErrGrammar, fmt.Sprintf("%s at %s", msg, pos))
+}
+
+const (
+	ebnfIdent   Terminal = "<ident>"
+	ebnfLiteral Terminal = "<literal>"
+	ebnfClass   Terminal = "<class>"
+	ebnfArrow   Terminal = "->"
+)
+
+func lexEBNF(src string) ([]Token, error) {
+	tokens := []Token{}
+	pos := Position{Line: 1, Column: 1}
+	runes := []rune(src)
+
+	advance := func(n int) string {
+		s := string(runes[:n])
+		for _, r := range runes[:n] {
+			pos.Offset += len(string(r))
+			if r == '\n' {
+				pos.Line++
+				pos.Column = 1
+			} else {
+				pos.Column++
+			}
+		}
+		runes = runes[n:]
+		return s
+	}
+
+	for len(runes) > 0 {
+		r := runes[0]
+		start := pos
+
+		switch {
+		case unicode.IsSpace(r):
+			advance(1)
+
+		case r == '#':
+			n := 0
+			for n < len(runes) && runes[n] != '\n' {
+				n++
+			}
+			advance(n)
+
+		case r == '-' && len(runes) > 1 && runes[1] == '>':
+			tokens = append(tokens, Token{Terminal: ebnfArrow, Text: advance(2), Pos: start})
+
+		case strings.ContainsRune("|[]{}()", r):
+			text := advance(1)
+			tokens = append(tokens, Token{Terminal: Terminal(text), Text: text, Pos: start})
+
+		case r == '"':
+			n := 1
+			for n < len(runes) && runes[n] != '"' {
+				if runes[n] == '\\' {
+					n++
+				}
+				n++
+			}
+			if n >= len(runes) {
+				return nil, grammarError(start, "unterminated literal")
+			}
+			text := advance(n + 1)
+			lit, err := strconv.Unquote(text)
+			if err != nil {
+				return nil, grammarError(start, fmt.Sprintf("invalid literal %s", text))
+			}
+			tokens = append(tokens, Token{Terminal: ebnfLiteral, Text: lit, Pos: start})
+
+		case r == '<':
+			n := 1
+			for n < len(runes) && runes[n] != '>' && runes[n] != '\n' {
+				n++
+			}
+			if n >= len(runes) || runes[n] != '>' || n == 1 {
+				return nil, grammarError(start, "invalid token class")
+			}
+			text := advance(n + 1)
+			tokens = append(tokens, Token{Terminal: ebnfClass, Text: text[1 : len(text)-1], Pos: start})
+
+		case isIdentRune(r, true):
+			n := 1
+			for n < len(runes) && isIdentRune(runes[n], false) {
+				n++
+			}
+			tokens = append(tokens, Token{Terminal: ebnfIdent, Text: advance(n), Pos: start})
+
+		default:
+			return nil, grammarError(start, fmt.Sprintf("unexpected character `%c`", r))
+		}
+	}
+
+	return tokens, nil
+}
+
+func isIdentRune(r rune, first bool) bool {
+	if first {
+		return r == '_' || unicode.IsLetter(r)
+	}
+	return r == '_' || r == '\'' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+type ebnfParser struct {
+	tokens  []Token
+	current int
+}
+
+func (p *ebnfParser) peek(i int) (Token, bool) {
+	if p.current+i >= len(p.tokens) {
+		return Token{}, false
+	}
+	return p.tokens[p.current+i], true
+}
+
+func (p *ebnfParser) check(sym Terminal) bool {
+	tok, ok := p.peek(0)
+	return ok && tok.Terminal == sym
+}
+
+func (p *ebnfParser) next() Token {
+	tok := p.tokens[p.current]
+	p.current++
+	return tok
+}
+
+func (p *ebnfParser) fail(msg string) error {
+	if tok, ok := p.peek(0); ok {
+		return grammarError(tok.Pos, fmt.Sprintf("%s near `%s`", msg, tok.Text))
+	}
+	return NewError(ErrGrammar, fmt.Sprintf("%s at end of grammar", msg))
+}
+
+func (p *ebnfParser) grammar() ([]*Rule, error) {
+	rules := []*Rule{}
+	for p.current < len(p.tokens) {
+		r, err := p.rule()
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+
+	if len(rules) == 0 {
+		return nil, NewError(ErrGrammar, "grammar has no rules")
+	}
+	return rules, nil
+}
+
+func (p *ebnfParser) rule() (*Rule, error) {
+	if !p.check(ebnfIdent) {
+		return nil, p.fail("expecting rule name")
+	}
+	name := p.next()
+
+	if !p.check(ebnfArrow) {
+		return nil, p.fail("expecting `->`")
+	}
+	p.next()
+
+	body, err := p.alt()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := p.peek(0); ok && !p.ruleStart() {
+		return nil, p.fail("unexpected token")
+	}
+
+	return &Rule{Name: NonTerminal(name.Text), Body: body, Pos: name.Pos}, nil
+}
+
+func (p *ebnfParser) ruleStart() bool {
+	next, ok := p.peek(1)
+	return p.check(ebnfIdent) && ok && next.Terminal == ebnfArrow
+}
+
+func (p *ebnfParser) alt() (*Expr, error) {
+	tok, _ := p.peek(0)
+
+	items := []*Expr{}
+	for {
+		seq, err := p.seq()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, seq)
+
+		if !p.check("|") {
+			break
+		}
+		p.next()
+	}
+
+	if len(items) == 1 {
+		return items[0], nil
+	}
+	return &Expr{Kind: ExprAlt, Items: items, Pos: tok.Pos}, nil
+}
+
+func (p *ebnfParser) seq() (*Expr, error) {
+	tok, _ := p.peek(0)
+
+	items := []*Expr{}
+	for {
+		if _, ok := p.peek(0); !ok || p.ruleStart() || p.check("|") || p.check(")") || p.check("]") || p.check("}") {
+			break
+		}
+
+		item, err := p.item()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	switch len(items) {
+	case 0:
+		return &Expr{Kind: ExprNull, Pos: tok.Pos}, nil
+	case 1:
+		return items[0], nil
+	}
+	return &Expr{Kind: ExprSeq, Items: items, Pos: tok.Pos}, nil
+}
+
+func (p *ebnfParser) item() (*Expr, error) {
+	tok := p.next()
+
+	switch tok.Terminal {
+	case ebnfIdent:
+		if tok.Text == "NULL" {
+			return &Expr{Kind: ExprNull, Pos: tok.Pos}, nil
+		}
+		return &Expr{Kind: ExprRef, Name: tok.Text, Pos: tok.Pos}, nil
+
+	case ebnfLiteral:
+		return &Expr{Kind: ExprLiteral, Name: tok.Text, Pos: tok.Pos}, nil
+
+	case ebnfClass:
+		return &Expr{Kind: ExprClass, Name: tok.Text, Pos: tok.Pos}, nil
+	}
+
+	closing := map[Terminal]Terminal{"(": ")", "[": "]", "{": "}"}
+	end, ok := closing[tok.Terminal]
+	if !ok {
+		p.current--
+		return nil, p.fail("unexpected token")
+	}
+
+	body, err := p.alt()
+	if err != nil {
+		return nil, err
+	}
+	if !p.check(end) {
+		return nil, p.fail(fmt.Sprintf("expecting `%s`", end))
+	}
+	p.next()
+
+	switch tok.Terminal {
+	case "[":
+		return &Expr{Kind: ExprOptional, Items: []*Expr{body}, Pos: tok.Pos}, nil
+	case "{":
+		return &Expr{Kind: ExprRepeat, Items: []*Expr{body}, Pos: tok.Pos}, nil
+	}
+	return body, nil
+}
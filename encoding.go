@@ -0,0 +1,328 @@
+package rdparser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/shivamMg/rd"
+)
+
+var ErrEncoding = fmt.Errorf("encoding error")
+
+// maxDecodeDepth bounds the nesting of decoded binary trees, matching the
+// limit encoding/json applies to JSON ones.
+const maxDecodeDepth = 10000
+
+const (
+	kindNonTerminal = "nonterminal"
+	kindTerminal    = "terminal"
+)
+
+type treeJSON struct {
+	Kind     string      `json:"kind"`
+	Symbol   string      `json:"symbol"`
+	Text     string      `json:"text,omitempty"`
+	Pos      *Position   `json:"pos,omitempty"`
+	Leading  string      `json:"leading,omitempty"`
+	Trailing string      `json:"trailing,omitempty"`
+	Children []*treeJSON `json:"children,omitempty"`
+}
+
+// MarshalJSON encodes the tree as nested nodes of kind "nonterminal" or
+// "terminal". Terminals that came from a Token also carry its text and
+// position.
+func (t *Tree) MarshalJSON() ([]byte, error) {
+	if t.Tree == nil {
+		return nil, NewError(ErrEncoding, "empty tree")
+	}
+	node, err := encodeJSON(t.Tree)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(node)
+}
+
+func (t *Tree) UnmarshalJSON(data []byte) error {
+	var node treeJSON
+	if err := json.Unmarshal(data, &node); err != nil {
+		return err
+	}
+
+	tree, err := decodeJSON(&node)
+	if err != nil {
+		return err
+	}
+	t.Tree = tree
+	return nil
+}
+
+func encodeJSON(t *rd.Tree) (*treeJSON, error) {
+	switch sym := t.Symbol.(type) {
+	case NonTerminal:
+		node := &treeJSON{Kind: kindNonTerminal, Symbol: sym.String(), Children: []*treeJSON{}}
+		for _, sub := range t.Subtrees {
+			child, err := encodeJSON(sub)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+		}
+		return node, nil
+	case Terminal:
+		return &treeJSON{Kind: kindTerminal, Symbol: sym.String()}, nil
+	case Token:
+		pos := sym.Pos
+		return &treeJSON{
+			Kind:     kindTerminal,
+			Symbol:   sym.Terminal.String(),
+			Text:     sym.Text,
+			Pos:      &pos,
+			Leading:  sym.Leading,
+			Trailing: sym.Trailing,
+		}, nil
+	}
+	return nil, NewError(ErrEncoding, fmt.Sprintf("unsupported symbol type %T", t.Symbol))
+}
+
+func decodeJSON(node *treeJSON) (*rd.Tree, error) {
+	switch node.Kind {
+	case kindNonTerminal:
+		t := rd.NewTree(NonTerminal(node.Symbol))
+		for _, child := range node.Children {
+			sub, err := decodeJSON(child)
+			if err != nil {
+				return nil, err
+			}
+			t.Add(sub)
+		}
+		return t, nil
+	case kindTerminal:
+		if len(node.Children) > 0 {
+			return nil, NewError(ErrEncoding, fmt.Sprintf("terminal `%s` cannot have children", node.Symbol))
+		}
+		if node.Pos == nil {
+			return rd.NewTree(Terminal(node.Symbol)), nil
+		}
+		return rd.NewTree(Token{
+			Terminal: Terminal(node.Symbol),
+			Text:     node.Text,
+			Pos:      *node.Pos,
+			Leading:  node.Leading,
+			Trailing: node.Trailing,
+		}), nil
+	}
+	return nil, NewError(ErrEncoding, fmt.Sprintf("invalid node kind `%s`", node.Kind))
+}
+
+const (
+	binaryMagic   = "RDT"
+	binaryVersion = 2
+
+	tagNonTerminal = 0
+	tagTerminal    = 1
+	tagToken       = 2
+)
+
+// MarshalBinary encodes the tree in pre-order after a table of the distinct
+// strings it uses, with all integers written as uvarints.
+func (t *Tree) MarshalBinary() ([]byte, error) {
+	if t.Tree == nil {
+		return nil, NewError(ErrEncoding, "empty tree")
+	}
+	enc := &binaryEncoder{index: map[string]int{}}
+	if err := enc.node(t.Tree); err != nil {
+		return nil, err
+	}
+
+	buf := []byte(binaryMagic)
+	buf = append(buf, binaryVersion)
+	buf = binary.AppendUvarint(buf, uint64(len(enc.strings)))
+	for _, s := range enc.strings {
+		buf = binary.AppendUvarint(buf, uint64(len(s)))
+		buf = append(buf, s...)
+	}
+	return append(buf, enc.body...), nil
+}
+
+func (t *Tree) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, []byte(binaryMagic)) || len(data) < len(binaryMagic)+1 {
+		return NewError(ErrEncoding, "not an encoded tree")
+	}
+	version := data[len(binaryMagic)]
+	if version < 1 || version > binaryVersion {
+		return NewError(ErrEncoding, fmt.Sprintf("unsupported version %d", version))
+	}
+
+	dec := &binaryDecoder{r: bytes.NewReader(data[len(binaryMagic)+1:]), trivia: version >= 2}
+
+	n, err := dec.uint()
+	if err != nil {
+		return err
+	}
+	if n > dec.r.Len() {
+		return NewError(ErrEncoding, "truncated string table")
+	}
+	for i := 0; i < n; i++ {
+		size, err := dec.uint()
+		if err != nil {
+			return err
+		}
+		if size > dec.r.Len() {
+			return NewError(ErrEncoding, "truncated string table")
+		}
+		s := make([]byte, size)
+		if _, err := io.ReadFull(dec.r, s); err != nil {
+			return NewError(ErrEncoding, "truncated string table")
+		}
+		dec.strings = append(dec.strings, string(s))
+	}
+
+	tree, err := dec.node(0)
+	if err != nil {
+		return err
+	}
+	if dec.r.Len() > 0 {
+		return NewError(ErrEncoding, "trailing data")
+	}
+	t.Tree = tree
+	return nil
+}
+
+type binaryEncoder struct {
+	strings []string
+	index   map[string]int
+	body    []byte
+}
+
+func (enc *binaryEncoder) uint(n int) {
+	enc.body = binary.AppendUvarint(enc.body, uint64(n))
+}
+
+func (enc *binaryEncoder) string(s string) {
+	i, ok := enc.index[s]
+	if !ok {
+		i = len(enc.strings)
+		enc.index[s] = i
+		enc.strings = append(enc.strings, s)
+	}
+	enc.uint(i)
+}
+
+func (enc *binaryEncoder) node(t *rd.Tree) error {
+	switch sym := t.Symbol.(type) {
+	case NonTerminal:
+		enc.body = append(enc.body, tagNonTerminal)
+		enc.string(sym.String())
+		enc.uint(len(t.Subtrees))
+		for _, sub := range t.Subtrees {
+			if err := enc.node(sub); err != nil {
+				return err
+			}
+		}
+	case Terminal:
+		enc.body = append(enc.body, tagTerminal)
+		enc.string(sym.String())
+	case Token:
+		enc.body = append(enc.body, tagToken)
+		enc.string(sym.Terminal.String())
+		enc.string(sym.Text)
+		enc.uint(sym.Pos.Offset)
+		enc.uint(sym.Pos.Line)
+		enc.uint(sym.Pos.Column)
+		enc.string(sym.Leading)
+		enc.string(sym.Trailing)
+	default:
+		return NewError(ErrEncoding, fmt.Sprintf("unsupported symbol type %T", t.Symbol))
+	}
+	return nil
+}
+
+type binaryDecoder struct {
+	r       *bytes.Reader
+	strings []string
+	trivia  bool
+}
+
+func (dec *binaryDecoder) uint() (int, error) {
+	n, err := binary.ReadUvarint(dec.r)
+	if err != nil || n > math.MaxInt32 {
+		return 0, NewError(ErrEncoding, "invalid integer")
+	}
+	return int(n), nil
+}
+
+func (dec *binaryDecoder) string() (string, error) {
+	i, err := dec.uint()
+	if err != nil {
+		return "", err
+	}
+	if i >= len(dec.strings) {
+		return "", NewError(ErrEncoding, "invalid string index")
+	}
+	return dec.strings[i], nil
+}
+
+func (dec *binaryDecoder) node(depth int) (*rd.Tree, error) {
+	if depth > maxDecodeDepth {
+		return nil, NewError(ErrEncoding, fmt.Sprintf("tree nested more than %d levels deep", maxDecodeDepth))
+	}
+
+	tag, err := dec.r.ReadByte()
+	if err != nil {
+		return nil, NewError(ErrEncoding, "truncated tree")
+	}
+
+	sym, err := dec.string()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tag {
+	case tagNonTerminal:
+		n, err := dec.uint()
+		if err != nil {
+			return nil, err
+		}
+		if n > dec.r.Len() {
+			return nil, NewError(ErrEncoding, "truncated tree")
+		}
+		t := rd.NewTree(NonTerminal(sym))
+		for i := 0; i < n; i++ {
+			sub, err := dec.node(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			t.Add(sub)
+		}
+		return t, nil
+
+	case tagTerminal:
+		return rd.NewTree(Terminal(sym)), nil
+
+	case tagToken:
+		tok := Token{Terminal: Terminal(sym)}
+		if tok.Text, err = dec.string(); err != nil {
+			return nil, err
+		}
+		for _, v := range []*int{&tok.Pos.Offset, &tok.Pos.Line, &tok.Pos.Column} {
+			if *v, err = dec.uint(); err != nil {
+				return nil, err
+			}
+		}
+		if dec.trivia {
+			if tok.Leading, err = dec.string(); err != nil {
+				return nil, err
+			}
+			if tok.Trailing, err = dec.string(); err != nil {
+				return nil, err
+			}
+		}
+		return rd.NewTree(tok), nil
+	}
+
+	return nil, NewError(ErrEncoding, fmt.Sprintf("invalid node tag %d", tag))
+}
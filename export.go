@@ -0,0 +1,151 @@
+package rdparser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shivamMg/rd"
+)
+
+type ExportOption func(o *exportOptions)
+
+type exportOptions struct {
+	collapseNull   bool
+	collapseChains bool
+}
+
+// CollapseNull leaves out non-terminals that matched nothing, such as the
+// NULL alternatives of Expr' and Term'.
+func CollapseNull() ExportOption {
+	return func(o *exportOptions) {
+		o.collapseNull = true
+	}
+}
+
+// CollapseChains merges a non-terminal having a single non-terminal child into
+// one node labelled with the whole chain, e.g. `Term / Factor / Number`.
+func CollapseChains() ExportOption {
+	return func(o *exportOptions) {
+		o.collapseChains = true
+	}
+}
+
+type exportNode struct {
+	id       string
+	label    string
+	terminal bool
+	error    bool
+	children []*exportNode
+}
+
+func (t *Tree) export(opts []ExportOption) *exportNode {
+	o := &exportOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	children := func(t *rd.Tree) []*rd.Tree {
+		if !o.collapseNull {
+			return t.Subtrees
+		}
+		subs := []*rd.Tree{}
+		for _, sub := range t.Subtrees {
+			if !IsNonTerminal(sub.Symbol) || len(sub.Subtrees) > 0 {
+				subs = append(subs, sub)
+			}
+		}
+		return subs
+	}
+
+	n := 0
+	var build func(t *rd.Tree) *exportNode
+	build = func(t *rd.Tree) *exportNode {
+		node := &exportNode{id: fmt.Sprintf("n%d", n)}
+		n++
+
+		if IsTerminal(t.Symbol) {
+			node.terminal = true
+			node.label = (&Tree{Tree: t}).AsToken().Text
+			return node
+		}
+
+		labels := []string{fmt.Sprint(t.Symbol)}
+		node.error = IsNonTerminalOf(t.Symbol, ErrorSymbol)
+		subs := children(t)
+		for o.collapseChains && len(subs) == 1 && IsNonTerminal(subs[0].Symbol) {
+			t = subs[0]
+			subs = children(t)
+			labels = append(labels, fmt.Sprint(t.Symbol))
+			node.error = node.error || IsNonTerminalOf(t.Symbol, ErrorSymbol)
+		}
+		node.label = strings.Join(labels, " / ")
+
+		for _, sub := range subs {
+			node.children = append(node.children, build(sub))
+		}
+		return node
+	}
+
+	return build(t.Tree)
+}
+
+func (t *Tree) DOT(opts ...ExportOption) string {
+	sb := &strings.Builder{}
+	sb.WriteString("digraph tree {\n")
+	sb.WriteString("\tnode [shape=ellipse];\n")
+
+	var write func(node *exportNode)
+	write = func(node *exportNode) {
+		attrs := fmt.Sprintf("label=%s", dotQuote(node.label))
+		switch {
+		case node.terminal:
+			attrs += ", shape=box"
+		case node.error:
+			attrs += ", color=red"
+		}
+		fmt.Fprintf(sb, "\t%s [%s];\n", node.id, attrs)
+
+		for _, child := range node.children {
+			write(child)
+			fmt.Fprintf(sb, "\t%s -> %s;\n", node.id, child.id)
+		}
+	}
+	write(t.export(opts))
+
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+func (t *Tree) Mermaid(opts ...ExportOption) string {
+	sb := &strings.Builder{}
+	sb.WriteString("graph TD\n")
+
+	var write func(node *exportNode)
+	write = func(node *exportNode) {
+		label := mermaidQuote(node.label)
+		if node.terminal {
+			fmt.Fprintf(sb, "\t%s[%s]\n", node.id, label)
+		} else {
+			fmt.Fprintf(sb, "\t%s(%s)\n", node.id, label)
+		}
+		if node.error {
+			fmt.Fprintf(sb, "\tstyle %s stroke:red\n", node.id)
+		}
+
+		for _, child := range node.children {
+			write(child)
+			fmt.Fprintf(sb, "\t%s --> %s\n", node.id, child.id)
+		}
+	}
+	write(t.export(opts))
+
+	return sb.String()
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+func mermaidQuote(s string) string {
+	return `"` + strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;", "\n", " ").Replace(s) + `"`
+}
@@ -0,0 +1,242 @@
+package rdparser
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+
+	"github.com/shivamMg/rd"
+)
+
+// Generator derives random sentences from an EBNF grammar, e.g. for load
+// tests and fuzzing. Once a derivation is more than the maximum depth deep,
+// only the alternatives closest to terminals are taken, so it always ends
+// unless the weights rule out every way to do so.
+type Generator struct {
+	g        *EBNF
+	rand     *rand.Rand
+	maxDepth int
+	weights  map[rd.Token]float64
+	classes  map[string]func(r *rand.Rand) string
+	rules    map[NonTerminal]func(depth int) []string
+	height   map[NonTerminal]int
+}
+
+func NewGenerator(g *EBNF, r *rand.Rand) *Generator {
+	return &Generator{
+		g:        g,
+		rand:     r,
+		maxDepth: 8,
+		weights:  make(map[rd.Token]float64),
+		classes:  make(map[string]func(r *rand.Rand) string),
+		rules:    make(map[NonTerminal]func(depth int) []string),
+	}
+}
+
+func (gen *Generator) MaxDepth(n int) *Generator {
+	gen.maxDepth = n
+	return gen
+}
+
+// Weight sets the relative weight, 1 by default, of the alternatives that
+// begin with sym, a Terminal or a NonTerminal. Alternatives of weight 0 are
+// never taken.
+func (gen *Generator) Weight(sym rd.Token, w float64) *Generator {
+	gen.weights[sym] = w
+	gen.height = nil
+	return gen
+}
+
+// Class sets how the text of tokens of the class name is made up.
+func (gen *Generator) Class(name string, fn func(r *rand.Rand) string) *Generator {
+	gen.classes[name] = fn
+	return gen
+}
+
+// Rule replaces the derivation of sym with fn, which returns its tokens and
+// may call Derive for the symbols inside.
+func (gen *Generator) Rule(sym NonTerminal, fn func(depth int) []string) *Generator {
+	gen.rules[sym] = fn
+	return gen
+}
+
+func (gen *Generator) Rand() *rand.Rand {
+	return gen.rand
+}
+
+// Generate derives a sentence from the start rule, its tokens separated by
+// spaces.
+func (gen *Generator) Generate() (s string, err error) {
+	defer Catch(ErrGrammar, &err)
+	return strings.Join(gen.Derive(gen.g.Start, 0), " "), nil
+}
+
+// Derive returns the tokens of a random derivation of sym at the given depth.
+// It panics with a grammar error for undefined rules and token classes, and
+// if the weights leave sym no derivation that ends.
+func (gen *Generator) Derive(sym NonTerminal, depth int) []string {
+	if fn, ok := gen.rules[sym]; ok {
+		return fn(depth)
+	}
+
+	r, ok := gen.g.Rule(sym)
+	if !ok {
+		panic(NewError(ErrGrammar, fmt.Sprintf("undefined rule `%s`", sym)))
+	}
+
+	if gen.height == nil {
+		gen.height = gen.heights()
+	}
+	if gen.height[sym] == math.MaxInt {
+		panic(NewError(ErrGrammar, fmt.Sprintf("the weights leave no derivation of `%s` that ends", sym)))
+	}
+
+	return gen.derive(r.Body, depth, nil)
+}
+
+func (gen *Generator) derive(e *Expr, depth int, tokens []string) []string {
+	switch e.Kind {
+	case ExprLiteral:
+		return append(tokens, e.Name)
+
+	case ExprClass:
+		fn, ok := gen.classes[e.Name]
+		if !ok {
+			panic(NewError(ErrGrammar, fmt.Sprintf("undefined token class `<%s>`", e.Name)))
+		}
+		return append(tokens, fn(gen.rand))
+
+	case ExprRef:
+		return append(tokens, gen.Derive(NonTerminal(e.Name), depth+1)...)
+
+	case ExprSeq:
+		for _, item := range e.Items {
+			tokens = gen.derive(item, depth, tokens)
+		}
+		return tokens
+
+	case ExprAlt:
+		return gen.derive(gen.choose(e.Items, depth), depth, tokens)
+
+	case ExprOptional:
+		if depth < gen.maxDepth && gen.rand.Intn(2) == 0 {
+			tokens = gen.derive(e.Items[0], depth, tokens)
+		}
+		return tokens
+
+	case ExprRepeat:
+		for depth < gen.maxDepth && gen.rand.Intn(2) == 0 {
+			tokens = gen.derive(e.Items[0], depth, tokens)
+		}
+		return tokens
+	}
+
+	return tokens
+}
+
+// choose picks one of the alternatives of weight above 0 that can end, past the
+// maximum depth only among those ending soonest.
+func (gen *Generator) choose(alts []*Expr, depth int) *Expr {
+	min := math.MaxInt
+	for _, alt := range alts {
+		if h := gen.exprHeight(alt, gen.height); gen.weight(alt) > 0 && h < min {
+			min = h
+		}
+	}
+	if min == math.MaxInt {
+		panic(NewError(ErrGrammar, "the weights leave no alternative that ends"))
+	}
+
+	candidates := []*Expr{}
+	total := 0.0
+	for _, alt := range alts {
+		h := gen.exprHeight(alt, gen.height)
+		if gen.weight(alt) <= 0 || h == math.MaxInt || depth >= gen.maxDepth && h > min {
+			continue
+		}
+		candidates = append(candidates, alt)
+		total += gen.weight(alt)
+	}
+
+	n := gen.rand.Float64() * total
+	for _, alt := range candidates {
+		if n -= gen.weight(alt); n < 0 {
+			return alt
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+func (gen *Generator) weight(e *Expr) float64 {
+	for e.Kind == ExprSeq && len(e.Items) > 0 {
+		e = e.Items[0]
+	}
+
+	var sym rd.Token
+	switch e.Kind {
+	case ExprLiteral:
+		sym = Terminal(e.Name)
+	case ExprRef:
+		sym = NonTerminal(e.Name)
+	default:
+		return 1
+	}
+
+	if w, ok := gen.weights[sym]; ok {
+		return w
+	}
+	return 1
+}
+
+// heights gives for every rule the least number of levels a derivation of it
+// needs through alternatives of weight above 0, or math.MaxInt if none ends.
+func (gen *Generator) heights() map[NonTerminal]int {
+	height := map[NonTerminal]int{}
+	for _, r := range gen.g.Rules {
+		height[r.Name] = math.MaxInt
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, r := range gen.g.Rules {
+			if h := gen.exprHeight(r.Body, height); h < height[r.Name] {
+				height[r.Name] = h
+				changed = true
+			}
+		}
+	}
+
+	return height
+}
+
+func (gen *Generator) exprHeight(e *Expr, height map[NonTerminal]int) int {
+	switch e.Kind {
+	case ExprRef:
+		h, ok := height[NonTerminal(e.Name)]
+		if !ok || h == math.MaxInt {
+			return math.MaxInt
+		}
+		return h + 1
+
+	case ExprSeq:
+		max := 0
+		for _, item := range e.Items {
+			if h := gen.exprHeight(item, height); h > max {
+				max = h
+			}
+		}
+		return max
+
+	case ExprAlt:
+		min := math.MaxInt
+		for _, item := range e.Items {
+			if h := gen.exprHeight(item, height); gen.weight(item) > 0 && h < min {
+				min = h
+			}
+		}
+		return min
+	}
+
+	return 0
+}
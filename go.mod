@@ -3,6 +3,6 @@ module github.com/michaelrk02/rdparser
 go 1.23.8
 
 require (
-	github.com/shivamMg/ppds v0.0.1 // indirect
-	github.com/shivamMg/rd v0.0.1 // indirect
+	github.com/shivamMg/ppds v0.0.1
+	github.com/shivamMg/rd v0.0.1
 )
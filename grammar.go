@@ -2,28 +2,172 @@ package rdparser
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/shivamMg/rd"
 )
 
 var ErrCompile = fmt.Errorf("compile error")
 
+const (
+	ErrorSymbol NonTerminal = "<error>"
+
+	rootSymbol NonTerminal = "<root>"
+)
+
 type Grammar interface {
 	BuildParseTree(ctx context.Context, b *Builder) error
 }
 
-func Compile(tokens []rd.Token, g Grammar) (*Tree, error) {
-	b := &Builder{Builder: rd.NewBuilder(tokens)}
+// Compile builds the parse tree of tokens, failing if the grammar leaves any
+// of them unconsumed. With WithRecovery, a failed compile is retried in
+// recovery mode and, if the grammar manages to resynchronize, the partial tree
+// is returned along with a *SyntaxErrors listing every problem; otherwise the
+// result is nil and the first error.
+func Compile(tokens []rd.Token, g Grammar, opts ...Option) (*Tree, error) {
+	o := newOptions(opts)
+
+	if o.maxTokens > 0 && len(tokens) > o.maxTokens {
+		return nil, &LimitError{Pos: PositionOf(tokens[o.maxTokens]), Limit: "tokens", Max: o.maxTokens}
+	}
+
+	// Each pass records into its own coverage, and only the pass whose
+	// result is returned counts.
+	var pass, retry *Coverage
+	if o.coverage != nil {
+		pass, retry = NewCoverage(), NewCoverage()
+	}
+
+	tree, err := compile(tokens, g, o, false, pass)
+	if err == nil || !o.recovery || errors.Is(err, ErrLimit) {
+		o.coverage.merge(pass)
+		return tree, err
+	}
+
+	tree, errs := compile(tokens, g, o, true, retry)
+	if errs == nil {
+		o.coverage.merge(pass)
+		return nil, err
+	}
+	o.coverage.merge(retry)
+	return tree, errs
+}
+
+func compile(tokens []rd.Token, g Grammar, o *options, recovering bool, cov *Coverage) (*Tree, error) {
+	var eof rd.Token
+	if n := len(tokens); n > 0 && IsTerminalOf(tokens[n-1], EOF) {
+		tokens, eof = tokens[:n-1], tokens[n-1]
+	}
+
+	b := newBuilder(tokens, o)
+	b.recovering = recovering
+	b.coverage = cov
+	if eof != nil {
+		b.end = PositionOf(eof)
+	}
+
+	ctx := context.Background()
+	b.enter(rootSymbol)
+
+	err := build(ctx, g, b)
+	if errors.Is(err, ErrLimit) {
+		return nil, err
+	}
+	if err == nil {
+		if tok, ok := b.Peek(1); ok {
+			msg := fmt.Sprintf("unexpected token `%s`", tok)
+			if recovering {
+				b.report(PositionOf(tok), msg)
+				b.skipTo(nil)
+			} else {
+				err = &SyntaxError{Pos: PositionOf(tok), Msg: msg}
+			}
+		}
+	}
+
+	ok := err == nil
+	b.Exit(&ok)
+
+	if recovering {
+		errs := b.errors
+		if err != nil {
+			errs = append(errs, asSyntaxError(err))
+		}
+		tree := b.tree()
+		cov.addTree(tree, b.annotator != nil)
+		tree.addEOF(eof)
+		if len(errs) == 0 {
+			return tree, nil
+		}
+		return tree, &SyntaxErrors{Errors: errs}
+	}
 
-	err := g.BuildParseTree(context.Background(), b)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Tree{Tree: b.ParseTree()}, nil
+	tree := b.tree()
+	cov.addTree(tree, b.annotator != nil)
+	tree.addEOF(eof)
+	return tree, nil
+}
+
+func build(ctx context.Context, g Grammar, b *Builder) (err error) {
+	defer Catch(ErrLimit, &err)
+	return g.BuildParseTree(ctx, b)
+}
+
+type SyntaxError struct {
+	Pos Position
+	Msg string
+}
+
+func (err *SyntaxError) Error() string {
+	if err.Pos.IsValid() {
+		return fmt.Sprintf("%s - %s at %s", ErrCompile, err.Msg, err.Pos)
+	}
+	return fmt.Sprintf("%s - %s", ErrCompile, err.Msg)
+}
+
+func (err *SyntaxError) Unwrap() error {
+	return ErrCompile
+}
+
+type SyntaxErrors struct {
+	Errors []*SyntaxError
+}
+
+func (errs *SyntaxErrors) Error() string {
+	msgs := make([]string, len(errs.Errors))
+	for i, err := range errs.Errors {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (errs *SyntaxErrors) Unwrap() []error {
+	unwrapped := make([]error, len(errs.Errors))
+	for i, err := range errs.Errors {
+		unwrapped[i] = err
+	}
+	return unwrapped
 }
 
 func NewSyntaxError(ctx context.Context, b *Builder) error {
-	return NewError(ErrCompile, fmt.Sprintf("invalid syntax near token `%s`", b.Last()))
+	if b.Last() == nil {
+		return &SyntaxError{Pos: b.pos(), Msg: "invalid syntax at the start of the input"}
+	}
+	return &SyntaxError{
+		Pos: PositionOf(b.Last()),
+		Msg: fmt.Sprintf("invalid syntax near token `%s`", b.Last()),
+	}
+}
+
+func asSyntaxError(err error) *SyntaxError {
+	if syntaxErr, ok := err.(*SyntaxError); ok {
+		return syntaxErr
+	}
+	return &SyntaxError{Msg: err.Error()}
 }
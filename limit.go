@@ -0,0 +1,41 @@
+package rdparser
+
+import (
+	"fmt"
+)
+
+var ErrLimit = fmt.Errorf("limit error")
+
+// LimitError reports input that is too large or too deeply nested to be
+// processed safely. Pos is where the limit was crossed, if known.
+type LimitError struct {
+	Pos   Position
+	Limit string
+	Max   int
+}
+
+func (err *LimitError) Error() string {
+	if err.Pos.IsValid() {
+		return fmt.Sprintf("%s - more than %d %s at %s", ErrLimit, err.Max, err.Limit, err.Pos)
+	}
+	return fmt.Sprintf("%s - more than %d %s", ErrLimit, err.Max, err.Limit)
+}
+
+func (err *LimitError) Unwrap() error {
+	return ErrLimit
+}
+
+// WithMaxTokens makes Compile reject input of more than n tokens.
+func WithMaxTokens(n int) Option {
+	return func(o *options) {
+		o.maxTokens = n
+	}
+}
+
+// WithMaxDepth makes Compile fail once non-terminals are nested more than n
+// levels deep, before the grammar's recursion can exhaust the stack.
+func WithMaxDepth(n int) Option {
+	return func(o *options) {
+		o.maxDepth = n
+	}
+}
@@ -0,0 +1,118 @@
+package rdparser
+
+import "github.com/shivamMg/rd"
+
+type memoKey struct {
+	sym   NonTerminal
+	index int
+}
+
+type memoEntry struct {
+	ok       bool
+	end      int
+	subtrees []*rd.Tree
+	errors   []*SyntaxError
+	last     rd.Token
+}
+
+type growth struct {
+	seed     *memoEntry
+	recursed bool
+}
+
+// Recall replays the memoized result of the non-terminal entered last at the
+// current position. It reports false if the rule has not been tried there yet
+// (or memoization is disabled), in which case the rule body should run.
+// Results are keyed by symbol and position only, so rules must not depend on
+// anything else in ctx.
+func (b *Builder) Recall(result *bool) bool {
+	f := b.frame()
+	sym, ok := f.sym.(NonTerminal)
+	if !ok {
+		return false
+	}
+	key := memoKey{sym: sym, index: f.index}
+
+	if g, found := b.growing[key]; found {
+		g.recursed = true
+		*result = b.replay(f, g.seed)
+		b.emit(Event{Kind: EventRecall, Symbol: sym, Depth: b.depth(), OK: *result})
+		return true
+	}
+
+	if entry, found := b.memo[key]; found {
+		*result = b.replay(f, entry)
+		b.emit(Event{Kind: EventRecall, Symbol: sym, Depth: b.depth(), OK: *result})
+		return true
+	}
+
+	return false
+}
+
+// Grow runs the body of a directly left-recursive rule such as
+// `Expr -> Expr "+" Term | Term`. The recursive call at the start of the rule
+// first fails, and each successful pass becomes the seed of the next one until
+// the match stops growing, which yields left-associative trees. The rule must
+// call Recall right after Enter.
+func (b *Builder) Grow(rule func() bool) bool {
+	f := b.frame()
+	key := memoKey{sym: f.sym.(NonTerminal), index: f.index}
+
+	if b.growing == nil {
+		b.growing = make(map[memoKey]*growth)
+	}
+
+	g := &growth{seed: &memoEntry{ok: false, last: b.last}}
+	b.growing[key] = g
+	defer delete(b.growing, key)
+
+	for {
+		ok := rule()
+		if !g.recursed {
+			return ok
+		}
+		if !ok || (g.seed.ok && b.current <= g.seed.end) {
+			break
+		}
+
+		g.seed = b.capture(f, true)
+		b.Backtrack()
+	}
+
+	b.Backtrack()
+	ok := b.replay(f, g.seed)
+	f.recalled = false
+	return ok
+}
+
+func (b *Builder) store(f *frame, ok bool) {
+	sym, isNonTerminal := f.sym.(NonTerminal)
+	if !isNonTerminal || sym == ErrorSymbol || sym == rootSymbol {
+		return
+	}
+
+	b.memo[memoKey{sym: sym, index: f.index}] = b.capture(f, ok)
+}
+
+func (b *Builder) capture(f *frame, ok bool) *memoEntry {
+	entry := &memoEntry{ok: ok, last: b.last}
+	if ok {
+		entry.end = b.current
+		entry.subtrees = f.node.Subtrees
+		entry.errors = append([]*SyntaxError{}, b.errors[f.errors:]...)
+	}
+	return entry
+}
+
+func (b *Builder) replay(f *frame, entry *memoEntry) bool {
+	f.recalled = true
+	b.last = entry.last
+
+	if entry.ok {
+		f.node.Subtrees = append([]*rd.Tree{}, entry.subtrees...)
+		b.current = entry.end
+		b.errors = append(b.errors, entry.errors...)
+	}
+
+	return entry.ok
+}
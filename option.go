@@ -0,0 +1,33 @@
+package rdparser
+
+type Option func(o *options)
+
+type options struct {
+	recovery  bool
+	memoize   bool
+	annotator Annotator
+	tracer    Tracer
+	coverage  *Coverage
+	maxTokens int
+	maxDepth  int
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func WithRecovery() Option {
+	return func(o *options) {
+		o.recovery = true
+	}
+}
+
+func WithMemoization() Option {
+	return func(o *options) {
+		o.memoize = true
+	}
+}
@@ -0,0 +1,84 @@
+package formula
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/michaelrk02/rdparser"
+)
+
+var keyEvaluation = contextKey("Evaluation")
+
+// Budget bounds a single Parser.Parse call. Zero fields are unlimited.
+// Formulas evaluate to numbers only: the language has no string values, and
+// argument lists, bounded by Args, are its only lists. The size of the source
+// text is bounded by the lexer's WithMaxLength instead.
+type Budget struct {
+	Nodes int // evaluated parse tree nodes
+	Depth int // nesting of evaluated nodes
+	Calls int // function calls
+	Args  int // arguments of a single function call
+}
+
+// ParserOption configures a Parser created by NewParser.
+type ParserOption func(p *Parser)
+
+// WithBudget makes Parse fail with a *BudgetError once b is exceeded.
+func WithBudget(b Budget) ParserOption {
+	return func(p *Parser) {
+		p.budget = b
+	}
+}
+
+// BudgetError reports which Budget field an evaluation exceeded, e.g.
+// "function calls", and at which node.
+type BudgetError struct {
+	Location
+	Budget string
+	Limit  int
+}
+
+func (err *BudgetError) Error() string {
+	return fmt.Sprintf("evaluation exceeds the budget of %d %s", err.Limit, err.Budget)
+}
+
+type evaluation struct {
+	nodes int
+	calls int
+}
+
+// enter extends the stack trace with sym before t is evaluated, and stops the
+// evaluation if the context is done or the node budgets are used up.
+func (p *Parser) enter(ctx context.Context, sym rdparser.NonTerminal, t *rdparser.Tree) context.Context {
+	ctx = rdparser.Trace(ctx, sym)
+
+	if err := ctx.Err(); err != nil {
+		p.fail(ctx, t, err)
+	}
+
+	if e, ok := ctx.Value(keyEvaluation).(*evaluation); ok {
+		e.nodes++
+		if p.budget.Nodes > 0 && e.nodes > p.budget.Nodes {
+			p.fail(ctx, t, &BudgetError{Budget: "nodes", Limit: p.budget.Nodes})
+		}
+	}
+
+	if p.budget.Depth > 0 && rdparser.TraceDepth(ctx) > p.budget.Depth {
+		p.fail(ctx, t, &BudgetError{Budget: "levels of nesting", Limit: p.budget.Depth})
+	}
+
+	return ctx
+}
+
+func (p *Parser) enterCall(ctx context.Context, t *rdparser.Tree, args []float64) {
+	if e, ok := ctx.Value(keyEvaluation).(*evaluation); ok {
+		e.calls++
+		if p.budget.Calls > 0 && e.calls > p.budget.Calls {
+			p.fail(ctx, t, &BudgetError{Budget: "function calls", Limit: p.budget.Calls})
+		}
+	}
+
+	if p.budget.Args > 0 && len(args) > p.budget.Args {
+		p.fail(ctx, t, &BudgetError{Budget: "arguments per call", Limit: p.budget.Args})
+	}
+}
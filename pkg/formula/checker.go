@@ -0,0 +1,385 @@
+package formula
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/michaelrk02/rdparser"
+	"github.com/michaelrk02/rdparser/pkg/formula/pattern"
+	"github.com/michaelrk02/rdparser/pkg/formula/symbol"
+	"github.com/michaelrk02/rdparser/pkg/formula/token"
+)
+
+var ErrCheck = fmt.Errorf("check error")
+
+type VariableSpec struct {
+	Type     Type
+	Required bool
+}
+
+type Schema map[string]VariableSpec
+
+func (s Schema) Validate(varDict VariableDict) error {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	problems := []Problem{}
+	for _, name := range names {
+		spec := s[name]
+		val, ok := varDict[name]
+		if !ok {
+			if spec.Required {
+				problems = append(problems, Problem{Err: fmt.Errorf("missing required variable `%s`", name)})
+			}
+			continue
+		}
+		if !spec.Type.Accepts(val) {
+			problems = append(problems, Problem{Err: &TypeError{Name: name, Expected: spec.Type, Actual: TypeOf(val)}})
+		}
+	}
+
+	if len(problems) > 0 {
+		return &CheckError{Problems: problems}
+	}
+	return nil
+}
+
+type Problem struct {
+	Pos rdparser.Position
+	Err error
+}
+
+func (p Problem) String() string {
+	if p.Pos.IsValid() {
+		return fmt.Sprintf("%s: %s", p.Pos, p.Err)
+	}
+	return p.Err.Error()
+}
+
+type CheckError struct {
+	Problems []Problem
+}
+
+func (err *CheckError) Error() string {
+	msgs := make([]string, len(err.Problems))
+	for i, p := range err.Problems {
+		msgs[i] = p.String()
+	}
+	return fmt.Sprintf("%s - %s", ErrCheck, strings.Join(msgs, "; "))
+}
+
+func (err *CheckError) Unwrap() []error {
+	errs := []error{ErrCheck}
+	for _, p := range err.Problems {
+		errs = append(errs, p.Err)
+	}
+	return errs
+}
+
+type Checker struct {
+	lib    Library
+	schema Schema
+
+	varRegex *regexp.Regexp
+}
+
+func NewChecker(lib Library, schema Schema) *Checker {
+	return &Checker{
+		lib:      lib,
+		schema:   schema,
+		varRegex: regexp.MustCompile(fmt.Sprintf(`^%s$`, pattern.Variable)),
+	}
+}
+
+func (c *Checker) Check(ctx context.Context, t *rdparser.Tree) (err error) {
+	defer rdparser.Catch(rdparser.ErrRuntime, &err)
+
+	run := &checkRun{Checker: c}
+	run.Expr(ctx, t)
+
+	if len(run.problems) > 0 {
+		sort.SliceStable(run.problems, func(i, j int) bool {
+			return run.problems[i].Pos.Offset < run.problems[j].Pos.Offset
+		})
+		err = &CheckError{Problems: run.problems}
+	}
+	return
+}
+
+type checkRun struct {
+	*Checker
+
+	problems []Problem
+}
+
+func (c *checkRun) report(t *rdparser.Tree, err error) {
+	if l, ok := err.(locatable); ok {
+		l.locate(t.Pos())
+	}
+	c.problems = append(c.problems, Problem{Pos: t.Pos(), Err: err})
+}
+
+func (c *checkRun) Expr(ctx context.Context, t *rdparser.Tree) Type {
+	if t.IsError() {
+		return TypeNumber
+	}
+
+	ctx = rdparser.Trace(ctx, symbol.Expr)
+
+	typ := c.Term(ctx, t.At(0).AssertNonTerminalOf(symbol.Term))
+
+	if t.At(1).AssertNonTerminalOf(symbol.Exprx).Has(2) {
+		typ = joinType(typ, c.Expr(ctx, assertOrError(t.At(1).At(1), symbol.Expr)))
+	}
+
+	return typ
+}
+
+func (c *checkRun) Term(ctx context.Context, t *rdparser.Tree) Type {
+	if t.IsError() {
+		return TypeNumber
+	}
+
+	ctx = rdparser.Trace(ctx, symbol.Term)
+
+	typ := c.Factor(ctx, t.At(0).AssertNonTerminalOf(symbol.Factor))
+
+	// Folded from the left like Parser.Term, so that `7 mod 2 / 2` is a
+	// number rather than an integer.
+	for rest := t.At(1).AssertNonTerminalOf(symbol.Termx); rest.Has(2); {
+		term := assertOrError(rest.At(1), symbol.Term)
+
+		rhs := TypeNumber
+		if !term.IsError() {
+			rhs = c.Factor(ctx, term.At(0).AssertNonTerminalOf(symbol.Factor))
+		}
+
+		switch rest.At(0).AsTerminal() {
+		case token.Div:
+			typ = TypeNumber
+		case token.Mod:
+			typ = TypeInteger
+		default:
+			typ = joinType(typ, rhs)
+		}
+
+		if term.IsError() {
+			break
+		}
+		rest = term.At(1).AssertNonTerminalOf(symbol.Termx)
+	}
+
+	return typ
+}
+
+func (c *checkRun) Factor(ctx context.Context, t *rdparser.Tree) Type {
+	if t.IsError() {
+		return TypeNumber
+	}
+
+	ctx = rdparser.Trace(ctx, symbol.Factor)
+
+	if t.At(0).IsTerminalOf(token.LParen) && t.At(1).IsNonTerminalOf(symbol.Factorx) {
+		if t.At(1).At(0).IsNonTerminalOf(symbol.BoolCond) {
+			return c.BoolCond(ctx, t.At(1).At(0))
+		}
+
+		if t.At(1).At(0).IsNonTerminalOf(symbol.Expr) {
+			return c.Expr(ctx, t.At(1).At(0))
+		}
+	}
+
+	if t.At(0).IsTerminalOf(token.Minus) {
+		return c.Factor(ctx, assertOrError(t.At(1), symbol.Factor))
+	}
+
+	if t.At(0).IsNonTerminalOf(symbol.Variable) {
+		return c.Variable(ctx, t.At(0))
+	}
+
+	if t.At(0).IsNonTerminalOf(symbol.Number) {
+		return c.Number(ctx, t.At(0))
+	}
+
+	if t.At(0).IsNonTerminalOf(symbol.FuncCall) {
+		return c.FuncCall(ctx, t.At(0))
+	}
+
+	panic(rdparser.NewRuntimeError("invalid expression"))
+}
+
+func (c *checkRun) FuncCall(ctx context.Context, t *rdparser.Tree) Type {
+	ctx = rdparser.Trace(ctx, symbol.FuncCall)
+
+	funcName := t.At(0).AssertNonTerminalOf(symbol.FuncName).At(0).AsTerminal().String()
+
+	t.At(1).AssertTerminalOf(token.LParen)
+	t.At(2).AssertNonTerminalOf(symbol.FuncArg)
+	t.At(3).AssertTerminalOf(token.RParen)
+
+	args, argTrees := c.FuncArg(ctx, t.At(2))
+
+	if _, ok := c.lib.Resolve(funcName); !ok {
+		c.report(t, &UnknownFunctionError{Name: funcName})
+		return TypeNumber
+	}
+
+	typed, ok := c.lib.(TypedLibrary)
+	if !ok {
+		return TypeNumber
+	}
+
+	sig, ok := typed.Signature(funcName)
+	if !ok {
+		return TypeNumber
+	}
+
+	if !sig.Accepts(len(args)) {
+		c.report(t, &ArityError{Func: funcName, Min: sig.MinArity(), Max: sig.MaxArity(), Got: len(args)})
+	}
+
+	for i, arg := range args {
+		if param, ok := sig.Param(i); ok && !arg.AssignableTo(param.Type) {
+			c.report(argTrees[i], &TypeError{Func: funcName, Name: param.Name, Expected: param.Type, Actual: arg})
+		}
+	}
+
+	return sig.Result
+}
+
+func (c *checkRun) FuncArg(ctx context.Context, t *rdparser.Tree) ([]Type, []*rdparser.Tree) {
+	ctx = rdparser.Trace(ctx, symbol.FuncArg)
+
+	args := []Type{}
+	argTrees := []*rdparser.Tree{}
+
+	for i := 0; i < t.Len(); i++ {
+		sub := t.At(i)
+		switch {
+		case sub.IsNonTerminalOf(symbol.Expr), sub.IsError():
+			args = append(args, c.Expr(ctx, sub))
+			argTrees = append(argTrees, sub)
+		case sub.IsNonTerminalOf(symbol.FuncArg), sub.IsNonTerminalOf(symbol.FuncArgx):
+			restArgs, restTrees := c.FuncArg(ctx, sub)
+			args = append(args, restArgs...)
+			argTrees = append(argTrees, restTrees...)
+		}
+	}
+
+	return args, argTrees
+}
+
+func (c *checkRun) BoolCond(ctx context.Context, t *rdparser.Tree) Type {
+	ctx = rdparser.Trace(ctx, symbol.BoolCond)
+
+	c.BoolExpr(ctx, t.At(0).AssertNonTerminalOf(symbol.BoolExpr))
+	t.At(1).AssertTerminalOf(token.Question)
+	t.At(3).AssertTerminalOf(token.Colon)
+
+	return joinType(
+		c.Expr(ctx, assertOrError(t.At(2), symbol.Expr)),
+		c.Expr(ctx, assertOrError(t.At(4), symbol.Expr)),
+	)
+}
+
+func (c *checkRun) BoolExpr(ctx context.Context, t *rdparser.Tree) {
+	if t.IsError() {
+		return
+	}
+
+	ctx = rdparser.Trace(ctx, symbol.BoolExpr)
+
+	c.BoolTerm(ctx, t.At(0).AssertNonTerminalOf(symbol.BoolTerm))
+
+	if t.At(1).AssertNonTerminalOf(symbol.BoolExprx).Has(2) {
+		c.BoolExpr(ctx, assertOrError(t.At(1).At(1), symbol.BoolExpr))
+	}
+}
+
+func (c *checkRun) BoolTerm(ctx context.Context, t *rdparser.Tree) {
+	if t.IsError() {
+		return
+	}
+
+	ctx = rdparser.Trace(ctx, symbol.BoolTerm)
+
+	c.BoolFactor(ctx, t.At(0).AssertNonTerminalOf(symbol.BoolFactor))
+
+	if t.At(1).AssertNonTerminalOf(symbol.BoolTermx).Has(2) {
+		c.BoolTerm(ctx, assertOrError(t.At(1).At(1), symbol.BoolTerm))
+	}
+}
+
+func (c *checkRun) BoolFactor(ctx context.Context, t *rdparser.Tree) {
+	ctx = rdparser.Trace(ctx, symbol.BoolFactor)
+
+	if t.Has(2) && t.At(0).IsNonTerminalOf(symbol.LogicNot) {
+		c.BoolFactor(ctx, t.At(1).AssertNonTerminalOf(symbol.BoolFactor))
+		return
+	}
+
+	if t.Has(3) && t.At(0).IsTerminalOf(token.LParen) {
+		c.BoolExpr(ctx, t.At(1).AssertNonTerminalOf(symbol.BoolExpr))
+		return
+	}
+
+	if t.Has(1) && t.At(0).IsNonTerminalOf(symbol.LogicExpr) {
+		c.LogicExpr(ctx, t.At(0))
+		return
+	}
+
+	panic(rdparser.NewRuntimeError("invalid expression"))
+}
+
+func (c *checkRun) LogicExpr(ctx context.Context, t *rdparser.Tree) {
+	ctx = rdparser.Trace(ctx, symbol.LogicExpr)
+
+	c.Expr(ctx, t.At(0).AssertNonTerminalOf(symbol.Expr))
+	t.At(1).AssertNonTerminalOf(symbol.LogicOp)
+	c.Expr(ctx, assertOrError(t.At(2), symbol.Expr))
+}
+
+func (c *checkRun) Variable(ctx context.Context, t *rdparser.Tree) Type {
+	varToken := t.At(0).AsTerminal().String()
+
+	varExtract := c.varRegex.FindStringSubmatch(varToken)
+	if varExtract == nil {
+		c.report(t, fmt.Errorf("invalid variable `%s`", varToken))
+		return TypeNumber
+	}
+	varName := varExtract[1]
+
+	spec, ok := c.schema[varName]
+	if !ok {
+		c.report(t, &UnknownVariableError{Name: varName})
+		return TypeNumber
+	}
+
+	return spec.Type
+}
+
+func (c *checkRun) Number(ctx context.Context, t *rdparser.Tree) Type {
+	numToken := t.At(0).AsTerminal().String()
+
+	rslt, err := strconv.ParseFloat(numToken, 64)
+	if err != nil {
+		c.report(t, fmt.Errorf("invalid number `%s`", numToken))
+		return TypeNumber
+	}
+
+	return TypeOf(rslt)
+}
+
+func assertOrError(t *rdparser.Tree, sym rdparser.NonTerminal) *rdparser.Tree {
+	if t.IsError() {
+		return t
+	}
+	return t.AssertNonTerminalOf(sym)
+}
@@ -0,0 +1,106 @@
+package formula
+
+import (
+	"fmt"
+
+	"github.com/michaelrk02/rdparser"
+)
+
+type Location struct {
+	Pos rdparser.Position
+}
+
+func (l *Location) locate(pos rdparser.Position) {
+	if !l.Pos.IsValid() {
+		l.Pos = pos
+	}
+}
+
+type locatable interface {
+	locate(pos rdparser.Position)
+}
+
+type UnknownVariableError struct {
+	Location
+	Name string
+}
+
+func (err *UnknownVariableError) Error() string {
+	return fmt.Sprintf("unknown variable `%s`", err.Name)
+}
+
+type UnknownFunctionError struct {
+	Location
+	Name string
+}
+
+func (err *UnknownFunctionError) Error() string {
+	return fmt.Sprintf("unknown function `%s`", err.Name)
+}
+
+type ArityError struct {
+	Location
+	Func string
+	Min  int
+	Max  int
+	Got  int
+}
+
+func (err *ArityError) Error() string {
+	return fmt.Sprintf("function `%s` expects %s, got %d", err.Func, arityString(err.Min, err.Max), err.Got)
+}
+
+type DivisionByZeroError struct {
+	Location
+	Op rdparser.Terminal
+}
+
+func (err *DivisionByZeroError) Error() string {
+	return fmt.Sprintf("division by zero in `%s`", err.Op)
+}
+
+type DomainError struct {
+	Location
+	Func string
+	Msg  string
+}
+
+func (err *DomainError) Error() string {
+	return fmt.Sprintf("domain error in `%s`: %s", err.Func, err.Msg)
+}
+
+// ValidationError is a failed Validator rule.
+type ValidationError struct {
+	Location
+	Func string
+	Msg  string
+}
+
+func (err *ValidationError) Error() string {
+	return fmt.Sprintf("invalid arguments to `%s`: %s", err.Func, err.Msg)
+}
+
+type TypeError struct {
+	Location
+	Func     string
+	Name     string
+	Expected Type
+	Actual   Type
+}
+
+func (err *TypeError) Error() string {
+	if err.Func == "" {
+		return fmt.Sprintf("variable `%s` expects %s, got %s", err.Name, err.Expected, err.Actual)
+	}
+	return fmt.Sprintf("argument `%s` of `%s` expects %s, got %s", err.Name, err.Func, err.Expected, err.Actual)
+}
+
+func arityString(lo, hi int) string {
+	switch {
+	case hi < 0:
+		return fmt.Sprintf("at least %d arguments", lo)
+	case lo == hi:
+		return fmt.Sprintf("%d arguments", lo)
+	}
+	return fmt.Sprintf("%d to %d arguments", lo, hi)
+}
@@ -0,0 +1,174 @@
+package formula
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/michaelrk02/rdparser"
+)
+
+type contextKey string
+
+var keyExplainStep = contextKey("ExplainStep")
+
+// Step is one evaluated node of an Explanation. Value is a float64 or a bool,
+// or nil if the evaluation failed before the node produced one. Note tells how
+// the value came about, e.g. the variable it was read from or which branch of
+// a condition was taken.
+type Step struct {
+	Symbol rdparser.NonTerminal
+	Pos    rdparser.Position
+	Source string
+	Value  interface{}
+	Note   string
+	Steps  []*Step
+}
+
+// Explanation records the steps of a Parser evaluation.
+type Explanation struct {
+	root Step
+}
+
+// Explain returns a context under which Parser.Parse records every evaluated
+// node into the returned Explanation.
+func Explain(ctx context.Context) (context.Context, *Explanation) {
+	e := &Explanation{}
+	return context.WithValue(ctx, keyExplainStep, &e.root), e
+}
+
+// Root returns the step of the outermost expression, or nil if nothing was
+// evaluated.
+func (e *Explanation) Root() *Step {
+	if len(e.root.Steps) == 0 {
+		return nil
+	}
+	return e.root.Steps[0].collapse()
+}
+
+// Tree renders the steps as an indented tree, leaving out nodes that only
+// pass on the value of their single child:
+//
+//	Expr `1 + x * 2` = 7 (1 + 6 = 7)
+//	  Number `1` = 1
+//	  Term `x * 2` = 6 (3 * 2 = 6)
+func (e *Explanation) Tree() string {
+	sb := &strings.Builder{}
+
+	var write func(s *Step, depth int)
+	write = func(s *Step, depth int) {
+		fmt.Fprintf(sb, "%s%s %s\n", strings.Repeat("  ", depth), s.Symbol, s)
+		for _, sub := range s.Steps {
+			write(sub.collapse(), depth+1)
+		}
+	}
+	if root := e.Root(); root != nil {
+		write(root, 0)
+	}
+
+	return sb.String()
+}
+
+// List renders the annotated steps one per line, numbered in the order they
+// were completed.
+func (e *Explanation) List() string {
+	sb := &strings.Builder{}
+
+	n := 0
+	var write func(s *Step)
+	write = func(s *Step) {
+		for _, sub := range s.Steps {
+			write(sub.collapse())
+		}
+		if s.Note != "" {
+			n++
+			fmt.Fprintf(sb, "%d. %s\n", n, s)
+		}
+	}
+	if root := e.Root(); root != nil {
+		write(root)
+	}
+
+	return sb.String()
+}
+
+func (e *Explanation) String() string {
+	return e.Tree()
+}
+
+func (s *Step) String() string {
+	value := "?"
+	if s.Value != nil {
+		value = fmt.Sprint(s.Value)
+	}
+
+	str := fmt.Sprintf("`%s` = %s", s.Source, value)
+	if s.Note != "" {
+		str += fmt.Sprintf(" (%s)", s.Note)
+	}
+	return str
+}
+
+func (s *Step) collapse() *Step {
+	for s.Note == "" && len(s.Steps) == 1 && s.Steps[0].Value == s.Value {
+		s = s.Steps[0]
+	}
+	return s
+}
+
+// explain starts the step for t under the current one when the evaluation is
+// being explained. The returned step is nil otherwise; its methods accept that.
+func explain(ctx context.Context, sym rdparser.NonTerminal, t *rdparser.Tree) (context.Context, *Step) {
+	parent, ok := ctx.Value(keyExplainStep).(*Step)
+	if !ok {
+		return ctx, nil
+	}
+
+	s := &Step{Symbol: sym, Pos: t.Pos(), Source: sourceText(t)}
+	parent.Steps = append(parent.Steps, s)
+	return context.WithValue(ctx, keyExplainStep, s), s
+}
+
+func (s *Step) note(format string, args ...interface{}) {
+	if s != nil {
+		s.Note = fmt.Sprintf(format, args...)
+	}
+}
+
+func (s *Step) number(v float64) float64 {
+	if s != nil {
+		s.Value = v
+	}
+	return v
+}
+
+func (s *Step) boolean(v bool) bool {
+	if s != nil {
+		s.Value = v
+	}
+	return v
+}
+
+func joinArgs(args []float64) string {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = fmt.Sprint(arg)
+	}
+	return strings.Join(strs, ", ")
+}
+
+// sourceText is the source of t without the trivia before its first and after
+// its last token.
+func sourceText(t *rdparser.Tree) string {
+	toks := []rdparser.Token{}
+	rdparser.NewVisitor().Leaf(func(t *rdparser.Tree) error {
+		toks = append(toks, t.AsToken())
+		return nil
+	}).Walk(t)
+	if len(toks) == 0 {
+		return ""
+	}
+
+	src := strings.TrimPrefix(t.Source(), toks[0].Leading)
+	return strings.TrimSuffix(src, toks[len(toks)-1].Trailing)
+}
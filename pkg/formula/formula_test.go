@@ -3,22 +3,63 @@ package formula
 import (
 	"context"
 	"encoding/csv"
+	"encoding/json"
 	"errors"
+	"flag"
+	"fmt"
 	"io"
+	"math"
+	"math/rand"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/michaelrk02/rdparser"
 	"github.com/michaelrk02/rdparser/pkg/formula/logic"
+	"github.com/michaelrk02/rdparser/pkg/formula/symbol"
+	"github.com/michaelrk02/rdparser/pkg/formula/token"
+	"github.com/michaelrk02/rdparser/rdtest"
+	"github.com/shivamMg/rd"
 )
 
 const (
 	Epsilon = 0.0
 
 	TestcaseFile = "testcases/example.csv"
+	GoldenFile   = "testcases/golden.txt"
 )
 
+var update = flag.Bool("update", false, "rewrite the golden file with the actual results")
+
+// lex lexes expr, failing t on a lexical error.
+func lex(t testing.TB, expr string) []rd.Token {
+	t.Helper()
+	tokens, err := NewLexer().Lex(expr)
+	if err != nil {
+		t.Fatalf("%s: %v", expr, err)
+	}
+	return tokens
+}
+
+// compile lexes expr and compiles it with NewGrammar, failing t on any error.
+func compile(t testing.TB, expr string, opts ...rdparser.Option) *rdparser.Tree {
+	t.Helper()
+	tree, err := rdparser.Compile(lex(t, expr), NewGrammar(), opts...)
+	if err != nil {
+		t.Fatalf("%s: %v", expr, err)
+	}
+	return tree
+}
+
+// eval compiles expr and evaluates it with parser.
+func eval(t testing.TB, parser rdparser.Parser, expr string) (interface{}, error) {
+	t.Helper()
+	return parser.Parse(context.Background(), compile(t, expr))
+}
+
 func TestFormula(t *testing.T) {
 	varDict := VariableDict{}
 
@@ -54,12 +95,14 @@ func TestFormula(t *testing.T) {
 
 		tokens, err := lexer.Lex(expr)
 		if err != nil {
-			panic(err)
+			t.Errorf("%d: %v", lineNum, err)
+			continue
 		}
 
 		tree, err := rdparser.Compile(tokens, grammar)
 		if err != nil {
-			panic(err)
+			t.Errorf("%d: %v", lineNum, err)
+			continue
 		}
 
 		rslt, err := parser.Parse(context.Background(), tree)
@@ -94,12 +137,1177 @@ func NewTestLib() Library {
 		StdLibrary: NewStdLibrary(),
 		ref:        make(map[string]Function),
 	}
+
+	lib.ref["fail"] = func(ctx context.Context, args []float64) (float64, error) {
+		return 0, errTestFail
+	}
+	lib.ref["crash"] = func(ctx context.Context, args []float64) (float64, error) {
+		return args[len(args)], nil
+	}
+	lib.ref["pair"] = func(ctx context.Context, args []float64) (float64, error) {
+		if err := Validate(ctx, "pair", args).ArgLength(2); err != nil {
+			return 0, err
+		}
+		return args[0] + args[1], nil
+	}
+	lib.ref["hang"] = func(ctx context.Context, args []float64) (float64, error) {
+		select {
+		case <-time.After(time.Second):
+			return 0, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
 	return lib
 }
 
+var errTestFail = errors.New("test failure")
+
 func (lib *TestLib) Resolve(funcName string) (Function, bool) {
 	if fn, ok := lib.ref[funcName]; ok {
 		return fn, true
 	}
 	return lib.StdLibrary.Resolve(funcName)
 }
+
+func TestGolden(t *testing.T) {
+	rdtest.Run(t, GoldenFile, rdtest.Pipeline{
+		Lexer:   NewLexer(),
+		Grammar: NewGrammar(),
+		Parser:  NewParser(NewTestLib(), Epsilon, VariableDict{"a": 2}),
+		Options: []rdparser.Option{rdparser.WithMemoization()},
+		Update:  *update,
+	})
+}
+
+func TestChecker(t *testing.T) {
+	schema := Schema{
+		"price":  {Type: TypeNumber, Required: true},
+		"digits": {Type: TypeInteger},
+	}
+	checker := NewChecker(NewStdLibrary(), schema)
+
+	cases := []struct {
+		expr     string
+		problems []string
+	}{
+		{"round([price] * 1.1, [digits])", nil},
+		{"sum(1, 2, 3) + max([price])", nil},
+		{"[qty] * foo(1)", []string{"1:1: unknown variable `qty`", "1:9: unknown function `foo`"}},
+		{"pow(2) + round(1, 2, 3)", []string{"1:1: function `pow` expects 2 arguments, got 1", "1:10: function `round` expects 2 arguments, got 3"}},
+		{"round(3.14, [price] / 2)", []string{"1:13: argument `digits` of `round` expects integer, got number"}},
+	}
+
+	for _, c := range cases {
+		err := checker.Check(context.Background(), compile(t, c.expr))
+
+		var checkErr *CheckError
+		if len(c.problems) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", c.expr, err)
+			}
+			continue
+		}
+		if !errors.As(err, &checkErr) {
+			t.Errorf("%s: expected check error, got %v", c.expr, err)
+			continue
+		}
+
+		actual := make([]string, len(checkErr.Problems))
+		for i, p := range checkErr.Problems {
+			actual[i] = p.String()
+		}
+		if strings.Join(actual, "\n") != strings.Join(c.problems, "\n") {
+			t.Errorf("%s: expected problems %q, got %q", c.expr, c.problems, actual)
+		}
+	}
+
+	if err := schema.Validate(VariableDict{"digits": 1.5}); err == nil {
+		t.Errorf("expected schema validation error")
+	} else {
+		t.Log(err)
+	}
+}
+
+func TestLibrarySignatures(t *testing.T) {
+	lib := NewStdLibrary()
+
+	names := []string{}
+	for _, sig := range lib.Functions() {
+		names = append(names, sig.Name)
+	}
+	if strings.Join(names, ",") != "average,avg,max,min,pow,round,sum" {
+		t.Errorf("unexpected functions %v", names)
+	}
+
+	for _, name := range []string{"min", "max", "sum", "avg", "average"} {
+		sig, _ := lib.Signature(name)
+		if sig.ArityString() != arityString(1, -1) || sig.Accepts(0) || !sig.Accepts(3) {
+			t.Errorf("%s: expected at least one argument, got %s", name, sig.ArityString())
+		}
+	}
+
+	parser := NewParser(lib, Epsilon, VariableDict{})
+	for _, expr := range []string{"pow(2)", "round(1.5, 0.5)", "pow(1, 2, 3)"} {
+		if _, err := eval(t, parser, expr); !errors.Is(err, rdparser.ErrRuntime) {
+			t.Errorf("%s: expected runtime error, got %v", expr, err)
+		}
+	}
+}
+
+func TestFunctionErrors(t *testing.T) {
+	parser := NewParser(NewTestLib(), Epsilon, VariableDict{})
+
+	cases := []struct {
+		expr  string
+		cause error
+		pos   string
+	}{
+		{"1 + fail(1)", errTestFail, "1:5"},
+		{"2 * (3 - crash(1))", nil, "1:10"},
+		{"1 - pair(1)", nil, "1:5"},
+	}
+
+	for _, c := range cases {
+		_, err := eval(t, parser, c.expr)
+
+		var runtimeErr *rdparser.RuntimeError
+		if !errors.As(err, &runtimeErr) {
+			t.Errorf("%s: expected runtime error, got %v", c.expr, err)
+			continue
+		}
+		if c.cause != nil && !errors.Is(err, c.cause) {
+			t.Errorf("%s: expected cause %v, got %v", c.expr, c.cause, err)
+		}
+		if runtimeErr.Pos.String() != c.pos {
+			t.Errorf("%s: expected call site %s, got %s", c.expr, c.pos, runtimeErr.Pos)
+		}
+		if found, _ := runtimeErr.StackTrace.Lookup(symbol.FuncCall); !found {
+			t.Errorf("%s: expected stack trace through FuncCall, got %s", c.expr, runtimeErr.StackTrace)
+		}
+	}
+
+	_, err := eval(t, parser, "pair(1, 2, 3)")
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Func != "pair" || !strings.Contains(err.Error(), "invalid arguments to `pair`: expected 2 arguments, got 3") {
+		t.Errorf("expected validation error of `pair`, got %v", err)
+	}
+}
+
+func TestRuntimeErrorKinds(t *testing.T) {
+	parser := NewParser(NewStdLibrary(), Epsilon, VariableDict{"zero": 0, "inf": math.Inf(1)})
+
+	var (
+		unknownVariable *UnknownVariableError
+		unknownFunction *UnknownFunctionError
+		arity           *ArityError
+		divisionByZero  *DivisionByZeroError
+		domain          *DomainError
+		typ             *TypeError
+	)
+
+	cases := []struct {
+		expr   string
+		target interface{}
+		pos    func() rdparser.Position
+		want   string
+	}{
+		{"1 + [missing]", &unknownVariable, func() rdparser.Position { return unknownVariable.Pos }, "1:5"},
+		{"2 * foo(1)", &unknownFunction, func() rdparser.Position { return unknownFunction.Pos }, "1:5"},
+		{"pow(1)", &arity, func() rdparser.Position { return arity.Pos }, "1:1"},
+		{"10 / [zero]", &divisionByZero, func() rdparser.Position { return divisionByZero.Pos }, "1:4"},
+		{"10 mod 0", &divisionByZero, func() rdparser.Position { return divisionByZero.Pos }, "1:4"},
+		{"[inf] mod 3", &domain, func() rdparser.Position { return domain.Pos }, "1:7"},
+		{"1 + pow(-8, 0.5)", &domain, func() rdparser.Position { return domain.Pos }, "1:5"},
+		{"round(1, 0.5)", &typ, func() rdparser.Position { return typ.Pos }, "1:1"},
+	}
+
+	for _, c := range cases {
+		_, err := eval(t, parser, c.expr)
+		if !errors.Is(err, rdparser.ErrRuntime) || !errors.As(err, c.target) {
+			t.Errorf("%s: expected %T, got %v", c.expr, c.target, err)
+			continue
+		}
+
+		if pos := c.pos(); pos.String() != c.want {
+			t.Errorf("%s: expected position %s, got %s", c.expr, c.want, pos)
+		}
+	}
+}
+
+func TestBudget(t *testing.T) {
+	cases := []struct {
+		expr   string
+		budget Budget
+		limit  string
+	}{
+		{"1 + 2 + 3", Budget{Nodes: 8}, "nodes"},
+		{"((((1))))", Budget{Depth: 8}, "levels of nesting"},
+		{"max(1, 2) + max(3, 4) + max(5, 6)", Budget{Calls: 2}, "function calls"},
+		{"sum(1, 2, 3, 4)", Budget{Args: 3}, "arguments per call"},
+	}
+
+	for _, c := range cases {
+		tree := compile(t, c.expr)
+
+		if _, err := NewParser(NewStdLibrary(), Epsilon, VariableDict{}).Parse(context.Background(), tree); err != nil {
+			t.Errorf("%s: unexpected error without budget: %v", c.expr, err)
+		}
+
+		_, err := NewParser(NewStdLibrary(), Epsilon, VariableDict{}, WithBudget(c.budget)).Parse(context.Background(), tree)
+		var budgetErr *BudgetError
+		if !errors.Is(err, rdparser.ErrRuntime) || !errors.As(err, &budgetErr) {
+			t.Errorf("%s: expected budget error, got %v", c.expr, err)
+			continue
+		}
+		if budgetErr.Budget != c.limit || !budgetErr.Pos.IsValid() {
+			t.Errorf("%s: unexpected budget error %s at %s", c.expr, budgetErr, budgetErr.Pos)
+		}
+	}
+
+	parser := NewParser(NewTestLib(), Epsilon, VariableDict{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := parser.Parse(ctx, compile(t, "1 + 2")); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancellation, got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := parser.Parse(ctx, compile(t, "1 + hang(1)")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("evaluation ran for %s past its deadline", elapsed)
+	}
+}
+
+func TestInputLimits(t *testing.T) {
+	deep := strings.Repeat("(", 10000) + "1" + strings.Repeat(")", 10000)
+
+	var limitErr *rdparser.LimitError
+
+	_, err := NewLexer(WithMaxLength(1000)).Lex(deep)
+	if !errors.As(err, &limitErr) || limitErr.Limit != "bytes of input" {
+		t.Errorf("expected length limit error, got %v", err)
+	}
+
+	_, err = NewLexer(WithMaxTokens(3)).Lex("1 + 2 * 3")
+	if !errors.As(err, &limitErr) || limitErr.Limit != "tokens" || limitErr.Pos.String() != "1:7" {
+		t.Errorf("expected token limit error at 1:7, got %v", err)
+	}
+
+	_, err = rdparser.Compile(lex(t, deep), NewGrammar(), rdparser.WithMaxDepth(1000), rdparser.WithRecovery(), rdparser.WithMemoization())
+	if !errors.Is(err, rdparser.ErrLimit) || !errors.As(err, &limitErr) || limitErr.Limit != "levels of nesting" {
+		t.Errorf("expected nesting limit error, got %v", err)
+	}
+
+	compile(t, strings.Repeat("(", 50)+"1"+strings.Repeat(")", 50), rdparser.WithMaxDepth(1000), rdparser.WithMemoization())
+}
+
+func TestRecovery(t *testing.T) {
+	checker := NewChecker(NewStdLibrary(), Schema{})
+
+	cases := []struct {
+		expr     string
+		errors   []string
+		problems int
+	}{
+		{"(1 + 2 * 3 -", []string{"1:13", "1:13"}, 0},
+		{"max(1, , [x]) + (4", []string{"1:8", "1:19"}, 1},
+		{"((1 > 0 ? 1 : [y] * ) + 3", []string{"1:21", "1:26"}, 1},
+		{"1 2", []string{"1:3"}, 0},
+	}
+
+	for _, c := range cases {
+		tokens := lex(t, c.expr)
+		if _, err := rdparser.Compile(tokens, NewGrammar()); !errors.Is(err, rdparser.ErrCompile) {
+			t.Errorf("%s: expected compile error without recovery, got %v", c.expr, err)
+		}
+
+		tree, err := rdparser.Compile(tokens, NewGrammar(), rdparser.WithRecovery())
+
+		var syntaxErrs *rdparser.SyntaxErrors
+		if !errors.As(err, &syntaxErrs) {
+			t.Errorf("%s: expected syntax errors, got %v", c.expr, err)
+			continue
+		}
+
+		positions := []string{}
+		for _, syntaxErr := range syntaxErrs.Errors {
+			positions = append(positions, syntaxErr.Pos.String())
+		}
+		if strings.Join(positions, ",") != strings.Join(c.errors, ",") {
+			t.Errorf("%s: expected errors at %v, got %v", c.expr, c.errors, err)
+		}
+
+		if tree == nil {
+			t.Errorf("%s: expected partial tree", c.expr)
+			continue
+		}
+
+		var checkErr *CheckError
+		if err := checker.Check(context.Background(), tree); c.problems > 0 && (!errors.As(err, &checkErr) || len(checkErr.Problems) != c.problems) {
+			t.Errorf("%s: expected %d problems, got %v", c.expr, c.problems, err)
+		} else if c.problems == 0 && err != nil {
+			t.Errorf("%s: unexpected check error %v", c.expr, err)
+		}
+	}
+}
+
+func TestMemoization(t *testing.T) {
+	exprs := []string{
+		"((((((1))))))",
+		"((1 > 0 && (2 < 3 || not (4 == 4))) ? max((1), (2)) : -(3))",
+		"(((1 + 2) * 3) > 4 ? 5 : 6) mod 4",
+	}
+
+	for _, expr := range exprs {
+		plain, memoized := compile(t, expr), compile(t, expr, rdparser.WithMemoization())
+		if plain.String() != memoized.String() {
+			t.Errorf("%s: memoized tree differs\n%s\n%s", expr, plain, memoized)
+		}
+	}
+}
+
+func TestGrammarText(t *testing.T) {
+	grammar := NewGrammar()
+	ebnf := grammar.EBNF()
+
+	exprs := []string{
+		"1 + 2 * 3 - 4 / 5 mod 6",
+		"max([x], -(1), pow(2, 3))",
+		"((1 > 0 && (2 < 3 || not (4 == 4))) ? 1 : 2)",
+		"max() + sum(1, )",
+		"1 +",
+	}
+
+	for _, expr := range exprs {
+		tokens := lex(t, expr)
+		expected, expectedErr := rdparser.Compile(tokens, grammar)
+		actual, actualErr := rdparser.Compile(tokens, ebnf)
+		if (expectedErr == nil) != (actualErr == nil) {
+			t.Errorf("%s: expected error %v, got %v", expr, expectedErr, actualErr)
+			continue
+		}
+
+		if expectedErr == nil && expected.String() != actual.String() {
+			t.Errorf("%s: tree differs\n%s\n%s", expr, expected, actual)
+		}
+	}
+
+	a := rdparser.Analyze(ebnf)
+	if len(a.Unreachable) > 0 || len(a.Unproductive) > 0 || len(a.LeftRecursion) > 0 {
+		t.Errorf("unexpected analysis:\n%s", a)
+	}
+
+	conflicting := map[rdparser.NonTerminal]bool{}
+	for _, c := range a.Conflicts {
+		conflicting[c.Rule] = true
+	}
+	for _, sym := range []rdparser.NonTerminal{symbol.Factorx, symbol.BoolFactor} {
+		if !conflicting[sym] {
+			t.Errorf("expected a conflict in %s:\n%s", sym, a)
+		}
+	}
+}
+
+func TestTreeEncoding(t *testing.T) {
+	parser := NewParser(NewStdLibrary(), Epsilon, VariableDict{"x": 3})
+
+	exprs := []string{
+		"(1.618 + 42) * max((7 > 7 ? 1 : 2), 3)",
+		"round([x] / 7, 2) - -1",
+	}
+
+	for _, expr := range exprs {
+		tree := compile(t, expr)
+		expected, err := parser.Parse(context.Background(), tree)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		jsonData, err := json.Marshal(tree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		binData, err := tree.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fromJSON, fromBin := &rdparser.Tree{}, &rdparser.Tree{}
+		if err := json.Unmarshal(jsonData, fromJSON); err != nil {
+			t.Fatal(err)
+		}
+		if err := fromBin.UnmarshalBinary(binData); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, decoded := range []*rdparser.Tree{fromJSON, fromBin} {
+			if decoded.String() != tree.String() || decoded.Pos() != tree.Pos() {
+				t.Errorf("%s: decoded tree differs\n%s\n%s", expr, tree, decoded)
+			}
+
+			actual, err := parser.Parse(context.Background(), decoded)
+			if err != nil || actual != expected {
+				t.Errorf("%s: expected %v, got %v (%v)", expr, expected, actual, err)
+			}
+		}
+
+		if !strings.Contains(string(jsonData), `"pos":{"offset":0,"line":1,"column":1}`) {
+			t.Errorf("%s: JSON lacks token positions: %s", expr, jsonData)
+		}
+	}
+
+	if err := (&rdparser.Tree{}).UnmarshalBinary([]byte("RDT\x01\x05")); !errors.Is(err, rdparser.ErrEncoding) {
+		t.Errorf("expected encoding error, got %v", err)
+	}
+
+	deep := "RDT\x02\x01\x01E" + strings.Repeat("\x00\x00\x01", 20000) + "\x01\x00"
+	if err := (&rdparser.Tree{}).UnmarshalBinary([]byte(deep)); !errors.Is(err, rdparser.ErrEncoding) {
+		t.Errorf("expected encoding error for deep nesting, got %v", err)
+	}
+
+	if _, err := json.Marshal(&rdparser.Tree{}); !errors.Is(err, rdparser.ErrEncoding) {
+		t.Errorf("expected encoding error for an empty tree, got %v", err)
+	}
+	if _, err := (&rdparser.Tree{}).MarshalBinary(); !errors.Is(err, rdparser.ErrEncoding) {
+		t.Errorf("expected encoding error for an empty tree, got %v", err)
+	}
+}
+
+// TestAssociativity guards the evaluation of operator chains, which the
+// grammar nests to the right but which are evaluated from the left.
+func TestAssociativity(t *testing.T) {
+	parser := NewParser(NewStdLibrary(), Epsilon, VariableDict{})
+
+	cases := []struct {
+		expr     string
+		expected float64
+	}{
+		{"1 - 2 - 3", -4},
+		{"10 - 4 + 3", 9},
+		{"64 / 4 / 2", 8},
+		{"7 mod 4 * 3", 9},
+		{"2 * 3 mod 4", 2},
+	}
+
+	for _, c := range cases {
+		if rslt, err := eval(t, parser, c.expr); err != nil || rslt != c.expected {
+			t.Errorf("%s: expected %v, got %v (%v)", c.expr, c.expected, rslt, err)
+		}
+	}
+}
+
+func TestVariables(t *testing.T) {
+	tree := compile(t, "[b] + max([a], [b] * 2, ([c] > 0 ? [a] : 1))")
+	if actual := strings.Join(Variables(tree), " "); actual != "b a c" {
+		t.Errorf("unexpected variables %s", actual)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	tree := compile(t, "[x] * 2 + ([x] > 2 or [y] > 0 ? round(2.5, 0) : -1) # why")
+
+	ctx, explanation := Explain(context.Background())
+	rslt, err := NewParser(NewStdLibrary(), 0, VariableDict{"x": 3}).Parse(ctx, tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rslt.(float64) != 9 {
+		t.Errorf("unexpected result %v", rslt)
+	}
+
+	expected := strings.Join([]string{
+		"1. `[x]` = 3 (variable `x`)",
+		"2. `[x] * 2` = 6 (3 * 2 = 6)",
+		"3. `[x]` = 3 (variable `x`)",
+		"4. `[x] > 2` = true (3 > 2 is true)",
+		"5. `[x] > 2 or [y] > 0` = true (left side of or is true, right side skipped)",
+		"6. `round(2.5, 0)` = 3 (round(2.5, 0) = 3)",
+		"7. `[x] > 2 or [y] > 0 ? round(2.5, 0) : -1` = 3 (condition is true, took `round(2.5, 0)`)",
+		"8. `[x] * 2 + ([x] > 2 or [y] > 0 ? round(2.5, 0) : -1)` = 9 (6 + 3 = 9)",
+		"",
+	}, "\n")
+	if actual := explanation.List(); actual != expected {
+		t.Errorf("unexpected list\n%s", actual)
+	}
+
+	if root := explanation.Root(); root.Symbol != symbol.Expr || len(root.Steps) != 2 {
+		t.Errorf("unexpected root %s", root)
+	}
+
+	if actual := strings.Split(explanation.Tree(), "\n")[1]; actual != "  Term `[x] * 2` = 6 (3 * 2 = 6)" {
+		t.Errorf("unexpected tree line %q", actual)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	tree := compile(t, "round([x], 2) + max(round(1.5, 0), (1 < 2 && [y] > 3 ? 4 : 5))")
+
+	cases := []struct {
+		query    string
+		expected []string
+	}{
+		{`FuncCall[> FuncName > "round"]`, []string{"1:1", "1:21"}},
+		{`FuncCall[!> FuncName > 'round']`, []string{"1:17"}},
+		{`FuncCall[FuncName > "round"]`, []string{"1:1", "1:17", "1:21"}},
+		{`BoolCond LogicExpr`, []string{"1:37", "1:46"}},
+		{`BoolCond > LogicExpr`, nil},
+		{`LogicExpr > LogicOp > *, Variable`, []string{"1:7", "1:39", "1:46", "1:50"}},
+		{`> Expr > Term > Factor > FuncCall > FuncName`, []string{"1:1"}},
+	}
+
+	for _, c := range cases {
+		matches, err := tree.Query(c.query)
+		if err != nil {
+			t.Errorf("%s: %v", c.query, err)
+			continue
+		}
+
+		actual := []string{}
+		for _, m := range matches {
+			actual = append(actual, m.Pos.String())
+		}
+		if strings.Join(actual, " ") != strings.Join(c.expected, " ") {
+			t.Errorf("%s: expected %v, got %v", c.query, c.expected, actual)
+		}
+	}
+
+	for _, query := range []string{"", "Expr >", "Expr[Term", `"x`, "Expr ?"} {
+		if _, err := rdparser.ParseQuery(query); !errors.Is(err, rdparser.ErrQuery) {
+			t.Errorf("%q: expected query error, got %v", query, err)
+		}
+	}
+}
+
+func TestSourceRoundTrip(t *testing.T) {
+	parser := NewParser(NewStdLibrary(), Epsilon, VariableDict{"x": 2})
+
+	cases := []struct {
+		input    string
+		expected float64
+	}{
+		{"1+2", 3},
+		{"  ( 1 +\t2 ) *3  ", 9},
+		{"/* price */ [x] * 10 # per unit\n", 20},
+		{"max(1, /* two */ 2,\n  # three\n  3)/*end*/", 3},
+	}
+
+	for _, c := range cases {
+		tree := compile(t, c.input)
+		if actual := tree.Source(); actual != c.input {
+			t.Errorf("expected source %q, got %q", c.input, actual)
+		}
+
+		data, err := tree.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := &rdparser.Tree{}
+		if err := decoded.UnmarshalBinary(data); err != nil || decoded.Source() != c.input {
+			t.Errorf("%q: trivia lost in encoding (%v)", c.input, err)
+		}
+
+		rslt, err := parser.Parse(context.Background(), tree)
+		if err != nil || rslt.(float64) != c.expected {
+			t.Errorf("%q: expected %v, got %v (%v)", c.input, c.expected, rslt, err)
+		}
+	}
+
+	for _, input := range []string{"1 /* open", "1 # ok\n/"} {
+		if tokens, err := NewLexer().Lex(input); err == nil {
+			if _, err := rdparser.Compile(tokens, NewGrammar()); err == nil {
+				t.Errorf("%q: expected an error", input)
+			}
+		}
+	}
+
+	tokens := lex(t, "/* x */")
+	if len(tokens) != 1 || !rdparser.IsTerminalOf(tokens[0], rdparser.EOF) || tokens[0].(rdparser.Token).Leading != "/* x */" {
+		t.Fatalf("expected the comment on a single EOF token, got %v", tokens)
+	}
+	var syntaxErr *rdparser.SyntaxError
+	if _, err := rdparser.Compile(tokens, NewGrammar()); !errors.As(err, &syntaxErr) || syntaxErr.Pos.String() != "1:8" {
+		t.Errorf("expected a syntax error at 1:8, got %v", err)
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	annotations := rdparser.Annotations{
+		symbol.FuncArg:  rdparser.List,
+		symbol.FuncArgx: rdparser.Inline,
+		symbol.Termx:    rdparser.Hidden,
+	}
+
+	for _, opts := range [][]rdparser.Option{
+		{rdparser.WithAnnotations(annotations)},
+		{rdparser.WithAnnotations(annotations), rdparser.WithMemoization()},
+	} {
+		tree := compile(t, "max(1, min(2, 3), 4)", opts...)
+
+		args := rdparser.MustParseQuery(`FuncCall > FuncArg`).Select(tree)
+		if len(args) != 2 || args[0].Tree.Len() != 3 || args[1].Tree.Len() != 2 {
+			t.Fatalf("expected flat argument lists, got\n%s", tree)
+		}
+		for i := 0; i < args[0].Tree.Len(); i++ {
+			args[0].Tree.At(i).AssertNonTerminalOf(symbol.Expr)
+		}
+
+		if hidden := rdparser.MustParseQuery(`Term', FuncArg'`).Select(tree); len(hidden) > 0 {
+			t.Errorf("expected annotated symbols to be gone, got\n%s", tree)
+		}
+	}
+}
+
+func BenchmarkNestedParens(b *testing.B) {
+	for _, bc := range []struct {
+		depth int
+		opts  []rdparser.Option
+	}{
+		{8, nil},
+		{8, []rdparser.Option{rdparser.WithMemoization()}},
+		{64, []rdparser.Option{rdparser.WithMemoization()}},
+		{512, []rdparser.Option{rdparser.WithMemoization()}},
+	} {
+		name := fmt.Sprintf("depth=%d/memo=%t", bc.depth, bc.opts != nil)
+		expr := strings.Repeat("(", bc.depth) + "1" + strings.Repeat(")", bc.depth)
+
+		tokens := lex(b, expr)
+
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := rdparser.Compile(tokens, NewGrammar(), bc.opts...); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func addSeeds(f *testing.F) {
+	file, err := os.Open(TestcaseFile)
+	if err != nil {
+		f.Fatal(err)
+	}
+	defer file.Close()
+
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, row := range rows {
+		f.Add(row[0])
+	}
+
+	for _, src := range []string{"", "((", "1 +", "max(", "/* 1", "[a] mod 0", "(1 > 2 ? 3)", "not not 1 < 2"} {
+		f.Add(src)
+	}
+}
+
+func FuzzLex(f *testing.F) {
+	addSeeds(f)
+	lexer := NewLexer(WithMaxLength(4096))
+
+	f.Fuzz(func(t *testing.T, src string) {
+		tokens, err := lexer.Lex(src)
+		if err != nil || len(tokens) == 0 {
+			return
+		}
+
+		sb := &strings.Builder{}
+		for _, tok := range tokens {
+			tok := tok.(rdparser.Token)
+			sb.WriteString(tok.Leading + tok.Text + tok.Trailing)
+		}
+		if sb.String() != src {
+			t.Errorf("tokens reproduce %q instead of %q", sb.String(), src)
+		}
+	})
+}
+
+func FuzzCompile(f *testing.F) {
+	addSeeds(f)
+	lexer := NewLexer(WithMaxLength(4096))
+	grammar := NewGrammar()
+
+	f.Fuzz(func(t *testing.T, src string) {
+		tokens, err := lexer.Lex(src)
+		if err != nil || len(tokens) == 0 {
+			return
+		}
+
+		tree, err := rdparser.Compile(tokens, grammar, rdparser.WithRecovery(), rdparser.WithMemoization(), rdparser.WithMaxDepth(200))
+		if err == nil && tree.Source() != src {
+			t.Errorf("tree reproduces %q instead of %q", tree.Source(), src)
+		}
+		if err != nil && !errors.Is(err, rdparser.ErrCompile) && !errors.Is(err, rdparser.ErrLimit) {
+			t.Errorf("unexpected error kind %v", err)
+		}
+	})
+}
+
+func FuzzParse(f *testing.F) {
+	addSeeds(f)
+	lexer := NewLexer(WithMaxLength(4096))
+	grammar := NewGrammar()
+	parser := NewParser(NewStdLibrary(), Epsilon, VariableDict{"a": 1, "b": -2.5}, WithBudget(Budget{Nodes: 10000}))
+
+	f.Fuzz(func(t *testing.T, src string) {
+		tokens, err := lexer.Lex(src)
+		if err != nil {
+			return
+		}
+
+		tree, err := rdparser.Compile(tokens, grammar, rdparser.WithMemoization(), rdparser.WithMaxDepth(200))
+		if err != nil {
+			return
+		}
+
+		rslt, err := parser.Parse(context.Background(), tree)
+		if err != nil {
+			if !errors.Is(err, rdparser.ErrRuntime) && !errors.Is(err, rdparser.ErrParse) {
+				t.Errorf("unexpected error kind %v", err)
+			}
+			return
+		}
+		if _, ok := rslt.(float64); !ok {
+			t.Errorf("unexpected result %#v", rslt)
+		}
+	})
+}
+
+// refExpr is a generated formula along with its meaning, evaluated without
+// the lexer, grammar or Parser. eval reports false where the formula must fail
+// at runtime.
+type refExpr interface {
+	prec() int
+	String() string
+	eval() (float64, bool)
+}
+
+type refCond interface {
+	prec() int
+	String() string
+	eval() (bool, bool)
+}
+
+type refNumber float64
+
+func (e refNumber) prec() int             { return 3 }
+func (e refNumber) String() string        { return strconv.FormatFloat(float64(e), 'f', -1, 64) }
+func (e refNumber) eval() (float64, bool) { return float64(e), true }
+
+type refVariable struct {
+	name  string
+	value float64
+}
+
+func (e refVariable) prec() int             { return 3 }
+func (e refVariable) String() string        { return "[" + e.name + "]" }
+func (e refVariable) eval() (float64, bool) { return e.value, true }
+
+type refNeg struct{ x refExpr }
+
+func (e refNeg) prec() int      { return 3 }
+func (e refNeg) String() string { return "-" + refParen(e.x, 3) }
+func (e refNeg) eval() (float64, bool) {
+	x, ok := e.x.eval()
+	return -x, ok
+}
+
+type refBinary struct {
+	op   string
+	x, y refExpr
+}
+
+func (e refBinary) prec() int {
+	if e.op == "+" || e.op == "-" {
+		return 1
+	}
+	return 2
+}
+
+// String leaves out every parenthesis that precedence and left-associativity
+// make redundant, so the parser has to get both right.
+func (e refBinary) String() string {
+	return refParen(e.x, e.prec()) + " " + e.op + " " + refParen(e.y, e.prec()+1)
+}
+
+func (e refBinary) eval() (float64, bool) {
+	x, ok := e.x.eval()
+	if !ok {
+		return 0, false
+	}
+	y, ok := e.y.eval()
+	if !ok {
+		return 0, false
+	}
+
+	switch e.op {
+	case "+":
+		return x + y, true
+	case "-":
+		return x - y, true
+	case "*":
+		return x * y, true
+	case "/":
+		return x / y, y != 0
+	}
+	if !isFinite(x) || !isFinite(y) || int(y) == 0 {
+		return 0, false
+	}
+	return float64(int(x) % int(y)), true
+}
+
+type refCall struct {
+	name string
+	args []refExpr
+}
+
+func (e refCall) prec() int { return 3 }
+
+func (e refCall) String() string {
+	args := make([]string, len(e.args))
+	for i, arg := range e.args {
+		args[i] = arg.String()
+	}
+	return e.name + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (e refCall) eval() (float64, bool) {
+	rslt := map[string]float64{"max": math.Inf(-1), "min": math.Inf(1), "sum": 0}[e.name]
+	for _, arg := range e.args {
+		x, ok := arg.eval()
+		if !ok {
+			return 0, false
+		}
+		switch e.name {
+		case "max":
+			rslt = math.Max(rslt, x)
+		case "min":
+			rslt = math.Min(rslt, x)
+		case "sum":
+			rslt += x
+		}
+	}
+	return rslt, true
+}
+
+type refTernary struct {
+	cond refCond
+	x, y refExpr
+}
+
+func (e refTernary) prec() int { return 3 }
+func (e refTernary) String() string {
+	return "(" + e.cond.String() + " ? " + e.x.String() + " : " + e.y.String() + ")"
+}
+
+func (e refTernary) eval() (float64, bool) {
+	cond, ok := e.cond.eval()
+	if !ok {
+		return 0, false
+	}
+	if cond {
+		return e.x.eval()
+	}
+	return e.y.eval()
+}
+
+type refCompare struct {
+	op   string
+	x, y refExpr
+}
+
+func (e refCompare) prec() int      { return 3 }
+func (e refCompare) String() string { return e.x.String() + " " + e.op + " " + e.y.String() }
+
+func (e refCompare) eval() (bool, bool) {
+	x, ok := e.x.eval()
+	if !ok {
+		return false, false
+	}
+	y, ok := e.y.eval()
+	if !ok {
+		return false, false
+	}
+
+	switch e.op {
+	case "==":
+		return x == y, true
+	case "!=":
+		return x != y, true
+	case "<":
+		return x < y, true
+	case "<=":
+		return x <= y, true
+	case ">":
+		return x > y, true
+	}
+	return x >= y, true
+}
+
+type refLogic struct {
+	op   string
+	x, y refCond
+}
+
+func (e refLogic) prec() int {
+	if e.op == "or" {
+		return 1
+	}
+	return 2
+}
+
+func (e refLogic) String() string {
+	return refParen(e.x, e.prec()) + " " + e.op + " " + refParen(e.y, e.prec())
+}
+
+func (e refLogic) eval() (bool, bool) {
+	x, ok := e.x.eval()
+	if !ok || x == (e.op == "or") {
+		return x, ok
+	}
+	return e.y.eval()
+}
+
+type refNot struct{ x refCond }
+
+func (e refNot) prec() int      { return 3 }
+func (e refNot) String() string { return "not " + refParen(e.x, 3) }
+func (e refNot) eval() (bool, bool) {
+	x, ok := e.x.eval()
+	return !x, ok
+}
+
+func refParen(e interface {
+	prec() int
+	String() string
+}, prec int) string {
+	if e.prec() < prec {
+		return "(" + e.String() + ")"
+	}
+	return e.String()
+}
+
+type refGenerator struct {
+	r    *rand.Rand
+	vars VariableDict
+}
+
+func (g *refGenerator) expr(depth int) refExpr {
+	if depth == 0 || g.r.Intn(4) == 0 {
+		if g.r.Intn(3) == 0 {
+			names := []string{}
+			for name := range g.vars {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			name := names[g.r.Intn(len(names))]
+			return refVariable{name: name, value: g.vars[name]}
+		}
+		return refNumber(float64(g.r.Intn(20)) / 2)
+	}
+
+	switch g.r.Intn(8) {
+	case 0:
+		return refNeg{g.expr(depth - 1)}
+	case 1:
+		args := make([]refExpr, 1+g.r.Intn(3))
+		for i := range args {
+			args[i] = g.expr(depth - 1)
+		}
+		return refCall{name: []string{"max", "min", "sum"}[g.r.Intn(3)], args: args}
+	case 2:
+		return refTernary{g.cond(depth - 1), g.expr(depth - 1), g.expr(depth - 1)}
+	}
+	return refBinary{[]string{"+", "-", "*", "/", "mod"}[g.r.Intn(5)], g.expr(depth - 1), g.expr(depth - 1)}
+}
+
+func (g *refGenerator) cond(depth int) refCond {
+	if depth == 0 || g.r.Intn(3) == 0 {
+		return refCompare{[]string{"==", "!=", "<", "<=", ">", ">="}[g.r.Intn(6)], g.expr(depth), g.expr(depth)}
+	}
+
+	switch g.r.Intn(3) {
+	case 0:
+		return refNot{g.cond(depth - 1)}
+	case 1:
+		return refLogic{"and", g.cond(depth - 1), g.cond(depth - 1)}
+	}
+	return refLogic{"or", g.cond(depth - 1), g.cond(depth - 1)}
+}
+
+func TestDifferential(t *testing.T) {
+	vars := VariableDict{"a": 3, "b": -2.5}
+	gen := &refGenerator{r: rand.New(rand.NewSource(47)), vars: vars}
+
+	parser := NewParser(NewStdLibrary(), 0, vars)
+
+	for i := 0; i < 500; i++ {
+		e := gen.expr(4)
+		src := e.String()
+		expected, ok := e.eval()
+
+		rslt, err := parser.Parse(context.Background(), compile(t, src, rdparser.WithMemoization()))
+		switch {
+		case !ok && err == nil:
+			t.Errorf("%s: expected runtime error, got %v", src, rslt)
+		case ok && err != nil:
+			t.Errorf("%s: expected %v, got %v", src, expected, err)
+		case ok && !logic.Equ(expected, rslt.(float64), 0):
+			t.Errorf("%s: expected %v, got %v", src, expected, rslt)
+		}
+	}
+}
+
+// TestGrammarCoverage reports which productions and terminals the CSV and
+// golden test cases leave untested; run with -v to see the full report.
+func TestGrammarCoverage(t *testing.T) {
+	inputs := []string{}
+
+	f, err := os.Open(TestcaseFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		inputs = append(inputs, row[0])
+	}
+
+	data, err := os.ReadFile(GoldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := rdtest.ParseTable(string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range table.Cases {
+		inputs = append(inputs, c.Input)
+	}
+
+	lexer := NewLexer()
+	grammar := NewGrammar()
+	cov := rdparser.NewCoverage()
+	for _, input := range inputs {
+		if tokens, err := lexer.Lex(input); err == nil {
+			rdparser.Compile(tokens, grammar, rdparser.WithCoverage(cov), rdparser.WithMemoization())
+		}
+	}
+
+	report := cov.Report(grammar.EBNF(), token.Dict()...)
+	for _, rc := range report.Rules {
+		if rc.Matched == 0 {
+			t.Errorf("%s is never matched", rc.Rule.Name)
+		}
+	}
+	t.Logf("coverage of %d inputs:\n%s", len(inputs), report)
+	for _, untested := range report.Untested() {
+		t.Logf("untested: %s", untested)
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	varDict := VariableDict{"a": 1, "b-2": 2}
+
+	gen := NewGenerator(NewTestLib(), WithSeed(50), WithVariables(varDict), WithDepth(8))
+	for i := 0; i < 300; i++ {
+		compile(t, mustGenerate(t, gen), rdparser.WithMemoization())
+	}
+
+	a := NewGenerator(NewStdLibrary(), WithSeed(1), WithWeight(token.Add, 0), WithWeight(symbol.FuncCall, 5))
+	b := NewGenerator(NewStdLibrary(), WithSeed(1), WithWeight(token.Add, 0), WithWeight(symbol.FuncCall, 5))
+	for i := 0; i < 50; i++ {
+		expr := mustGenerate(t, a)
+		if expr != mustGenerate(t, b) {
+			t.Fatalf("same seed generated different formulas")
+		}
+		if strings.Contains(expr, "+") || strings.Contains(expr, "[") {
+			t.Errorf("unexpected addition or variable in %s", expr)
+		}
+	}
+
+	gen = NewGenerator(NewTestLib(), WithSeed(1), WithFunctions("pow", "unknown"), WithWeight(symbol.FuncCall, 5))
+	for i := 0; i < 50; i++ {
+		fields := strings.Fields(mustGenerate(t, gen))
+		for j := 1; j < len(fields); j++ {
+			name := fields[j-1]
+			if fields[j] == "(" && name[0] >= 'a' && name[0] <= 'z' && name != "pow" && !isKeyword(name) {
+				t.Errorf("unexpected function %s", name)
+			}
+		}
+	}
+
+	gen = NewGenerator(NewStdLibrary(), WithSeed(1), WithWeight(symbol.Variable, 1))
+	for i := 0; i < 50; i++ {
+		if expr := mustGenerate(t, gen); strings.Contains(expr, "[") {
+			t.Errorf("unexpected variable in %s", expr)
+		}
+	}
+
+	gen = NewGenerator(NewStdLibrary(), WithSeed(1), WithWeight(symbol.Number, 0), WithVariables(varDict))
+	for i := 0; i < 50; i++ {
+		expr := mustGenerate(t, gen)
+		for _, field := range strings.Fields(expr) {
+			if field[0] >= '0' && field[0] <= '9' && !strings.Contains(expr, "round (") {
+				t.Errorf("unexpected number in %s", expr)
+			}
+		}
+	}
+
+	lib := NewStdLibrary()
+	lib.Register(Signature{Name: "one", Result: TypeNumber, Pure: true}, func(ctx context.Context, args []float64) (float64, error) {
+		return 1, nil
+	})
+	parser := NewParser(lib, Epsilon, VariableDict{})
+	gen = NewGenerator(lib, WithSeed(1), WithFunctions("one"), WithWeight(symbol.FuncCall, 5))
+	empty := 0
+	for i := 0; i < 50; i++ {
+		expr := mustGenerate(t, gen)
+		if strings.Contains(expr, "one ( )") {
+			empty++
+		}
+
+		if _, err := eval(t, parser, expr); err != nil && !errors.Is(err, rdparser.ErrRuntime) {
+			t.Errorf("%s: %v", expr, err)
+		}
+	}
+	if empty == 0 {
+		t.Errorf("expected calls without arguments")
+	}
+
+	gen = NewGenerator(NewStdLibrary(), WithWeight(symbol.Number, 0))
+	if _, err := gen.Generate(); !errors.Is(err, rdparser.ErrGrammar) {
+		t.Errorf("expected grammar error without numbers or variables, got %v", err)
+	}
+}
+
+func mustGenerate(t *testing.T, gen *Generator) string {
+	t.Helper()
+	expr, err := gen.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return expr
+}
+
+func isKeyword(s string) bool {
+	for _, tok := range token.Dict() {
+		if tok.String() == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package formula
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/michaelrk02/rdparser"
+	"github.com/michaelrk02/rdparser/pkg/formula/symbol"
+	"github.com/shivamMg/rd"
+)
+
+type GeneratorOption func(g *Generator)
+
+// WithDepth bounds how deeply the grammar rules of generated formulas nest.
+func WithDepth(n int) GeneratorOption {
+	return func(g *Generator) {
+		g.depth = n
+	}
+}
+
+// WithWeight sets the relative weight, 1 by default, of the alternatives that
+// begin with sym, e.g. token.Add for additions or symbol.BoolCond for
+// conditions. As token.Sub and token.Minus are the same terminal, a weight for
+// either applies to both subtraction and negation.
+func WithWeight(sym rd.Token, w float64) GeneratorOption {
+	return func(g *Generator) {
+		g.weights[sym] = w
+	}
+}
+
+// WithVariables makes the variables of varDict appear in generated formulas.
+func WithVariables(varDict VariableDict) GeneratorOption {
+	return func(g *Generator) {
+		for name := range varDict {
+			g.variables = append(g.variables, name)
+		}
+		sort.Strings(g.variables)
+	}
+}
+
+// WithFunctions limits the functions called by generated formulas to names.
+// Functions without a signature get one to three arguments.
+func WithFunctions(names ...string) GeneratorOption {
+	return func(g *Generator) {
+		g.names = names
+	}
+}
+
+func WithSeed(seed int64) GeneratorOption {
+	return func(g *Generator) {
+		g.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// Generator produces random formulas that are valid according to GrammarText,
+// calling the functions of a library with as many arguments as their
+// signatures accept.
+type Generator struct {
+	lib       Library
+	depth     int
+	weights   map[rd.Token]float64
+	variables []string
+	names     []string
+	functions []Signature
+	rand      *rand.Rand
+
+	gen *rdparser.Generator
+}
+
+func NewGenerator(lib Library, opts ...GeneratorOption) *Generator {
+	g := &Generator{
+		lib:     lib,
+		depth:   10,
+		weights: make(map[rd.Token]float64),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	if g.rand == nil {
+		g.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	typed, isTyped := lib.(TypedLibrary)
+	switch {
+	case g.names != nil:
+		for _, name := range g.names {
+			if _, ok := lib.Resolve(name); !ok {
+				continue
+			}
+			sig := Signature{Name: name, Params: []Param{{Name: "x", Type: TypeNumber}}, Variadic: true}
+			if isTyped {
+				if typedSig, ok := typed.Signature(name); ok {
+					sig = typedSig
+				}
+			}
+			g.functions = append(g.functions, sig)
+		}
+	case isTyped:
+		g.functions = typed.Functions()
+	}
+
+	g.gen = rdparser.NewGenerator(NewGrammar().EBNF(), g.rand).
+		MaxDepth(g.depth).
+		Class("number", g.number).
+		Class("variable", g.variable).
+		Class("function", g.function).
+		Rule(symbol.FuncCall, g.funcCall)
+
+	for sym, w := range g.weights {
+		g.gen.Weight(sym, w)
+	}
+	if len(g.variables) == 0 {
+		g.gen.Weight(symbol.Variable, 0)
+	}
+	if len(g.functions) == 0 {
+		g.gen.Weight(symbol.FuncCall, 0)
+	}
+
+	return g
+}
+
+// Generate returns a random formula, or an rdparser.ErrGrammar error if the
+// weights leave it nothing to end on. Numbers and variables are the only
+// leaves, as function calls always take arguments.
+func (g *Generator) Generate() (string, error) {
+	if g.weight(symbol.Number) <= 0 && (len(g.variables) == 0 || g.weight(symbol.Variable) <= 0) {
+		return "", rdparser.NewError(rdparser.ErrGrammar, "the weights leave neither numbers nor variables to generate")
+	}
+	return g.gen.Generate()
+}
+
+func (g *Generator) weight(sym rd.Token) float64 {
+	if w, ok := g.weights[sym]; ok {
+		return w
+	}
+	return 1
+}
+
+func (g *Generator) number(r *rand.Rand) string {
+	if r.Intn(4) == 0 {
+		return fmt.Sprintf("%d.%d", r.Intn(100), r.Intn(100))
+	}
+	return fmt.Sprint(r.Intn(100))
+}
+
+func (g *Generator) variable(r *rand.Rand) string {
+	return fmt.Sprintf("[%s]", g.variables[r.Intn(len(g.variables))])
+}
+
+func (g *Generator) function(r *rand.Rand) string {
+	return g.functions[r.Intn(len(g.functions))].Name
+}
+
+// funcCall passes as many arguments as the signature accepts, up to two more
+// than the minimum for variadic functions.
+func (g *Generator) funcCall(depth int) []string {
+	sig := g.functions[g.rand.Intn(len(g.functions))]
+
+	min, max := sig.MinArity(), sig.MaxArity()
+	if max < min {
+		max = min + 2
+	}
+	n := min + g.rand.Intn(max-min+1)
+
+	tokens := []string{sig.Name, "("}
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			tokens = append(tokens, ",")
+		}
+		if param, _ := sig.Param(i); param.Type == TypeInteger {
+			tokens = append(tokens, fmt.Sprint(g.rand.Intn(5)))
+		} else {
+			tokens = append(tokens, g.gen.Derive(symbol.Expr, depth+1)...)
+		}
+	}
+	return append(tokens, ")")
+}
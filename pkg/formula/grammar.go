@@ -2,6 +2,7 @@ package formula
 
 import (
 	"context"
+	_ "embed"
 	"fmt"
 	"regexp"
 
@@ -9,39 +10,14 @@ import (
 	"github.com/michaelrk02/rdparser/pkg/formula/pattern"
 	"github.com/michaelrk02/rdparser/pkg/formula/symbol"
 	"github.com/michaelrk02/rdparser/pkg/formula/token"
+	"github.com/shivamMg/rd"
 )
 
-/*
-	Grammar:
-
-	Expr		-> Term Expr'
-	Expr'		-> "+" Expr | "-" Expr | NULL
-	Term		-> Factor Term'
-	Term'		-> "*" Term | "/" Term | "mod" Term | NULL
-	Factor		-> "(" Factor' | "-" Factor | Variable | Number | FuncCall
-	Factor'		->  BoolCond ")" | Expr ")"
-
-	FuncCall	-> FuncName "(" FuncArg ")"
-	FuncName	-> <function>
-	FuncArg		-> Expr FuncArg' | NULL
-	FuncArg'	-> "," FuncArg | NULL
-
-	BoolCond	-> BoolExpr "?" Expr ":" Expr
-	BoolExpr	-> BoolTerm BoolExpr'
-	BoolExpr'	-> LogicOr BoolExpr | NULL
-	BoolTerm	-> BoolFactor BoolTerm'
-	BoolTerm'	-> LogicAnd BoolTerm | NULL
-	BoolFactor	-> LogicNot BoolFactor | "(" BoolExpr ")" | LogicExpr
-
-	LogicExpr	-> Expr LogicOp Expr
-	LogicOr		-> "||" | "or"
-	LogicAnd	-> "&&" | "and"
-	LogicNot	-> "!" | "~" | "not"
-	LogicOp		-> "==" | "!=" | "~=" | "<>" | "<=" | ">=" | "<" | ">"
-
-	Variable	-> <variable>
-	Number		-> <number>
-*/
+// GrammarText is the grammar of formulas, the productions that Grammar
+// implements by hand.
+//
+//go:embed grammar.ebnf
+var GrammarText string
 
 type Grammar struct {
 	FunctionPattern *regexp.Regexp
@@ -57,6 +33,21 @@ func NewGrammar() *Grammar {
 	}
 }
 
+// EBNF loads GrammarText with the token classes matched by g's patterns.
+func (g *Grammar) EBNF() *rdparser.EBNF {
+	return rdparser.MustParseEBNF(GrammarText).
+		Class("function", g.matcher(g.FunctionPattern)).
+		Class("variable", g.matcher(g.VariablePattern)).
+		Class("number", g.matcher(g.NumberPattern))
+}
+
+func (g *Grammar) matcher(pattern *regexp.Regexp) func(tok rd.Token) bool {
+	return func(tok rd.Token) bool {
+		sym, _ := rdparser.TerminalOf(tok)
+		return pattern.MatchString(sym.String())
+	}
+}
+
 func (g *Grammar) BuildParseTree(ctx context.Context, b *rdparser.Builder) (err error) {
 	defer rdparser.Catch(rdparser.ErrCompile, &err)
 
@@ -71,19 +62,25 @@ func (g *Grammar) BuildParseTree(ctx context.Context, b *rdparser.Builder) (err
 
 func (g *Grammar) Expr(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	defer b.Enter(&ctx, symbol.Expr).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
 
 	return g.Term(ctx, b) && g.Exprx(ctx, b)
 }
 
 func (g *Grammar) Exprx(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	defer b.Enter(&ctx, symbol.Exprx).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
 
 	if b.Match(token.Add) {
-		return g.Expr(ctx, b)
+		return g.Expr(ctx, b) || g.Recover(ctx, b)
 	}
 
 	if b.Match(token.Sub) {
-		return g.Expr(ctx, b)
+		return g.Expr(ctx, b) || g.Recover(ctx, b)
 	}
 
 	return true
@@ -91,23 +88,29 @@ func (g *Grammar) Exprx(ctx context.Context, b *rdparser.Builder) (ok bool) {
 
 func (g *Grammar) Term(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	defer b.Enter(&ctx, symbol.Term).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
 
 	return g.Factor(ctx, b) && g.Termx(ctx, b)
 }
 
 func (g *Grammar) Termx(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	defer b.Enter(&ctx, symbol.Termx).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
 
 	if b.Match(token.Mul) {
-		return g.Term(ctx, b)
+		return g.Term(ctx, b) || g.Recover(ctx, b)
 	}
 
 	if b.Match(token.Div) {
-		return g.Term(ctx, b)
+		return g.Term(ctx, b) || g.Recover(ctx, b)
 	}
 
 	if b.Match(token.Mod) {
-		return g.Term(ctx, b)
+		return g.Term(ctx, b) || g.Recover(ctx, b)
 	}
 
 	return true
@@ -115,13 +118,16 @@ func (g *Grammar) Termx(ctx context.Context, b *rdparser.Builder) (ok bool) {
 
 func (g *Grammar) Factor(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	defer b.Enter(&ctx, symbol.Factor).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
 
 	if b.Match(token.LParen) {
 		return g.Factorx(ctx, b)
 	}
 
 	if b.Match(token.Minus) {
-		return g.Factor(ctx, b)
+		return g.Factor(ctx, b) || g.Recover(ctx, b)
 	}
 
 	if g.Variable(ctx, b) || g.Number(ctx, b) {
@@ -133,13 +139,16 @@ func (g *Grammar) Factor(ctx context.Context, b *rdparser.Builder) (ok bool) {
 
 func (g *Grammar) Factorx(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	defer b.Enter(&ctx, symbol.Factorx).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
 
 	if g.BoolCond(ctx, b) {
-		return b.Match(token.RParen)
+		return b.Expect(ctx, token.RParen)
 	}
 
 	if g.Expr(ctx, b) {
-		return b.Match(token.RParen)
+		return b.Expect(ctx, token.RParen)
 	}
 
 	return false
@@ -147,19 +156,26 @@ func (g *Grammar) Factorx(ctx context.Context, b *rdparser.Builder) (ok bool) {
 
 func (g *Grammar) FuncCall(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	defer b.Enter(&ctx, symbol.FuncCall).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
 
-	return g.FuncName(ctx, b) && b.Match(token.LParen) && g.FuncArg(ctx, b) && b.Match(token.RParen)
+	return g.FuncName(ctx, b) && b.Match(token.LParen) && g.FuncArg(ctx, b) && b.Expect(ctx, token.RParen)
 }
 
 func (g *Grammar) FuncName(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	defer b.Enter(&ctx, symbol.FuncName).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
 
 	tok, ok := b.Next()
 	if !ok {
 		return false
 	}
 
-	if g.FunctionPattern.MatchString(tok.(rdparser.Terminal).String()) {
+	sym, _ := rdparser.TerminalOf(tok)
+	if g.FunctionPattern.MatchString(sym.String()) {
 		b.Add(tok)
 		return true
 	}
@@ -169,12 +185,29 @@ func (g *Grammar) FuncName(ctx context.Context, b *rdparser.Builder) (ok bool) {
 
 func (g *Grammar) FuncArg(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	defer b.Enter(&ctx, symbol.FuncArg).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
+
+	if g.Expr(ctx, b) {
+		return g.FuncArgx(ctx, b)
+	}
+
+	// An argument list may be empty, but when recovering a missing argument
+	// followed by more of the list is reported rather than left to the
+	// closing parenthesis.
+	if b.Recovering() && !b.Check(token.RParen, 1) {
+		return g.Recover(ctx, b) && g.FuncArgx(ctx, b)
+	}
 
-	return g.Expr(ctx, b) && g.FuncArgx(ctx, b)
+	return true
 }
 
 func (g *Grammar) FuncArgx(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	defer b.Enter(&ctx, symbol.FuncArgx).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
 
 	if b.Match(token.Comma) {
 		return g.FuncArg(ctx, b)
@@ -185,21 +218,32 @@ func (g *Grammar) FuncArgx(ctx context.Context, b *rdparser.Builder) (ok bool) {
 
 func (g *Grammar) BoolCond(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	defer b.Enter(&ctx, symbol.BoolCond).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
 
-	return g.BoolExpr(ctx, b) && b.Match(token.Question) && g.Expr(ctx, b) && b.Match(token.Colon) && g.Expr(ctx, b)
+	return g.BoolExpr(ctx, b) && b.Match(token.Question) &&
+		(g.Expr(ctx, b) || g.Recover(ctx, b)) && b.Expect(ctx, token.Colon) &&
+		(g.Expr(ctx, b) || g.Recover(ctx, b))
 }
 
 func (g *Grammar) BoolExpr(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	defer b.Enter(&ctx, symbol.BoolExpr).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
 
 	return g.BoolTerm(ctx, b) && g.BoolExprx(ctx, b)
 }
 
 func (g *Grammar) BoolExprx(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	defer b.Enter(&ctx, symbol.BoolExprx).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
 
 	if g.LogicOr(ctx, b) {
-		return g.BoolExpr(ctx, b)
+		return g.BoolExpr(ctx, b) || g.Recover(ctx, b)
 	}
 
 	return true
@@ -207,15 +251,21 @@ func (g *Grammar) BoolExprx(ctx context.Context, b *rdparser.Builder) (ok bool)
 
 func (g *Grammar) BoolTerm(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	defer b.Enter(&ctx, symbol.BoolTerm).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
 
 	return g.BoolFactor(ctx, b) && g.BoolTermx(ctx, b)
 }
 
 func (g *Grammar) BoolTermx(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	defer b.Enter(&ctx, symbol.BoolTermx).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
 
 	if g.LogicAnd(ctx, b) {
-		return g.BoolTerm(ctx, b)
+		return g.BoolTerm(ctx, b) || g.Recover(ctx, b)
 	}
 
 	return true
@@ -223,6 +273,9 @@ func (g *Grammar) BoolTermx(ctx context.Context, b *rdparser.Builder) (ok bool)
 
 func (g *Grammar) BoolFactor(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	defer b.Enter(&ctx, symbol.BoolFactor).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
 
 	if g.LogicNot(ctx, b) {
 		return g.BoolFactor(ctx, b)
@@ -238,37 +291,53 @@ func (g *Grammar) BoolFactor(ctx context.Context, b *rdparser.Builder) (ok bool)
 
 func (g *Grammar) LogicExpr(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	defer b.Enter(&ctx, symbol.LogicExpr).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
 
-	return g.Expr(ctx, b) && g.LogicOp(ctx, b) && g.Expr(ctx, b)
+	return g.Expr(ctx, b) && g.LogicOp(ctx, b) && (g.Expr(ctx, b) || g.Recover(ctx, b))
 }
 
 func (g *Grammar) LogicOr(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	defer b.Enter(&ctx, symbol.LogicOr).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
 
 	return b.Match(token.OrNotation) || b.Match(token.OrText)
 }
 
 func (g *Grammar) LogicAnd(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	defer b.Enter(&ctx, symbol.LogicAnd).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
 
 	return b.Match(token.AndNotation) || b.Match(token.AndText)
 }
 
 func (g *Grammar) LogicNot(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	defer b.Enter(&ctx, symbol.LogicNot).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
 
 	return b.Match(token.NotNotationA) || b.Match(token.NotNotationB) || b.Match(token.NotText)
 }
 
 func (g *Grammar) LogicOp(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	defer b.Enter(&ctx, symbol.LogicOp).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
 
 	tok, ok := b.Next()
 	if !ok {
 		return false
 	}
 
-	if g.IsLogicOp(tok.(rdparser.Terminal)) {
+	sym, _ := rdparser.TerminalOf(tok)
+	if g.IsLogicOp(sym) {
 		b.Add(tok)
 		return true
 	}
@@ -278,13 +347,17 @@ func (g *Grammar) LogicOp(ctx context.Context, b *rdparser.Builder) (ok bool) {
 
 func (g *Grammar) Variable(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	defer b.Enter(&ctx, symbol.Variable).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
 
 	tok, ok := b.Next()
 	if !ok {
 		return false
 	}
 
-	if g.VariablePattern.MatchString(tok.(rdparser.Terminal).String()) {
+	sym, _ := rdparser.TerminalOf(tok)
+	if g.VariablePattern.MatchString(sym.String()) {
 		b.Add(tok)
 		return true
 	}
@@ -294,13 +367,17 @@ func (g *Grammar) Variable(ctx context.Context, b *rdparser.Builder) (ok bool) {
 
 func (g *Grammar) Number(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	defer b.Enter(&ctx, symbol.Number).Exit(&ok)
+	if b.Recall(&ok) {
+		return ok
+	}
 
 	tok, ok := b.Next()
 	if !ok {
 		return false
 	}
 
-	if g.NumberPattern.MatchString(tok.(rdparser.Terminal).String()) {
+	sym, _ := rdparser.TerminalOf(tok)
+	if g.NumberPattern.MatchString(sym.String()) {
 		b.Add(tok)
 		return true
 	}
@@ -308,6 +385,14 @@ func (g *Grammar) Number(ctx context.Context, b *rdparser.Builder) (ok bool) {
 	return false
 }
 
+func (g *Grammar) Recover(ctx context.Context, b *rdparser.Builder) bool {
+	return b.Recover(ctx, "expecting expression",
+		token.RParen, token.Comma, token.Question, token.Colon,
+		token.Equ, token.NotEquA, token.NotEquB, token.NotEquC, token.LTEqu, token.GTEqu, token.LT, token.GT,
+		token.OrNotation, token.OrText, token.AndNotation, token.AndText,
+	)
+}
+
 func (g *Grammar) IsLogicOp(tok rdparser.Terminal) bool {
 	return tok == token.Equ ||
 		tok == token.NotEquA ||
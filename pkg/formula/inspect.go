@@ -0,0 +1,29 @@
+package formula
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/michaelrk02/rdparser"
+	"github.com/michaelrk02/rdparser/pkg/formula/pattern"
+	"github.com/michaelrk02/rdparser/pkg/formula/symbol"
+)
+
+var variableRegex = regexp.MustCompile(fmt.Sprintf(`^%s$`, pattern.Variable))
+
+// Variables returns the names of the variables referenced in t, in order of
+// first appearance.
+func Variables(t *rdparser.Tree) []string {
+	names := []string{}
+	seen := map[string]bool{}
+
+	rdparser.NewVisitor().Pre(symbol.Variable, func(t *rdparser.Tree) error {
+		if m := variableRegex.FindStringSubmatch(t.At(0).AsTerminal().String()); m != nil && !seen[m[1]] {
+			seen[m[1]] = true
+			names = append(names, m[1])
+		}
+		return rdparser.SkipChildren
+	}).Walk(t)
+
+	return names
+}
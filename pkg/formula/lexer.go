@@ -14,10 +14,30 @@ import (
 type Lexer struct {
 	LanguagePattern *regexp.Regexp
 	TokenPattern    *regexp.Regexp
+	CommentPattern  *regexp.Regexp
+
+	// MaxLength and MaxTokens bound the input in bytes and tokens. Zero
+	// values are unlimited.
+	MaxLength int
+	MaxTokens int
 }
 
-func NewLexer() rdparser.Lexer {
-	lexPattern := []string{}
+type LexerOption func(l *Lexer)
+
+func WithMaxLength(n int) LexerOption {
+	return func(l *Lexer) {
+		l.MaxLength = n
+	}
+}
+
+func WithMaxTokens(n int) LexerOption {
+	return func(l *Lexer) {
+		l.MaxTokens = n
+	}
+}
+
+func NewLexer(opts ...LexerOption) rdparser.Lexer {
+	lexPattern := []string{pattern.Comment}
 
 	tokenDict := token.Dict()
 	for _, tok := range tokenDict {
@@ -29,22 +49,85 @@ func NewLexer() rdparser.Lexer {
 	tokenPattern := strings.Join(lexPattern, "|")
 	languagePattern := fmt.Sprintf(`^\s*(\s*|%s)*\s*$`, tokenPattern)
 
-	return &Lexer{
+	l := &Lexer{
 		LanguagePattern: regexp.MustCompile(languagePattern),
 		TokenPattern:    regexp.MustCompile(tokenPattern),
+		CommentPattern:  regexp.MustCompile(fmt.Sprintf(`^%s$`, pattern.Comment)),
+	}
+	for _, opt := range opts {
+		opt(l)
 	}
+	return l
 }
 
 func (t *Lexer) Lex(input string) ([]rd.Token, error) {
+	if t.MaxLength > 0 && len(input) > t.MaxLength {
+		return nil, &rdparser.LimitError{Limit: "bytes of input", Max: t.MaxLength}
+	}
+
 	if !t.LanguagePattern.MatchString(input) {
 		return nil, rdparser.NewLexicalError("input string is not recognizable")
 	}
 
-	tokenStrings := t.TokenPattern.FindAllString(input, -1)
-	tokenResult := make([]rd.Token, len(tokenStrings))
-	for i, token := range tokenStrings {
-		tokenResult[i] = rdparser.Terminal(strings.ToLower(token))
+	tokenIndices := t.TokenPattern.FindAllStringIndex(input, -1)
+	tokenResult := make([]rd.Token, 0, len(tokenIndices))
+
+	// Whitespace and comments are kept as the leading trivia of the next
+	// token, or the trailing trivia of the last one, so that the tree can
+	// reproduce the input.
+	pos := rdparser.Position{Offset: 0, Line: 1, Column: 1}
+	for _, loc := range tokenIndices {
+		text := input[loc[0]:loc[1]]
+		if t.CommentPattern.MatchString(text) {
+			continue
+		}
+		if text == token.Div.String() && strings.HasPrefix(input[loc[1]:], token.Mul.String()) {
+			return nil, rdparser.NewLexicalError("unterminated comment")
+		}
+
+		leading := input[pos.Offset:loc[0]]
+		pos = advance(pos, leading)
+
+		if t.MaxTokens > 0 && len(tokenResult) == t.MaxTokens {
+			return nil, &rdparser.LimitError{Pos: pos, Limit: "tokens", Max: t.MaxTokens}
+		}
+
+		tokenResult = append(tokenResult, rdparser.Token{
+			Terminal: rdparser.Terminal(strings.ToLower(text)),
+			Text:     text,
+			Pos:      pos,
+			Leading:  leading,
+		})
+
+		pos = advance(pos, text)
+	}
+
+	// Input without tokens keeps its trivia on an rdparser.EOF token.
+	if n := len(tokenResult); n > 0 {
+		last := tokenResult[n-1].(rdparser.Token)
+		last.Trailing = input[pos.Offset:]
+		tokenResult[n-1] = last
+	} else {
+		leading := input[pos.Offset:]
+		tokenResult = append(tokenResult, rdparser.Token{
+			Terminal: rdparser.EOF,
+			Pos:      advance(pos, leading),
+			Leading:  leading,
+		})
 	}
 
 	return tokenResult, nil
 }
+
+func advance(pos rdparser.Position, s string) rdparser.Position {
+	for _, c := range s {
+		if c == '\n' {
+			pos.Line++
+			pos.Column = 1
+		} else {
+			pos.Column++
+		}
+	}
+	pos.Offset += len(s)
+	return pos
+}
@@ -4,36 +4,97 @@ import (
 	"context"
 	"fmt"
 	"math"
-
-	"github.com/michaelrk02/rdparser"
+	"sort"
 )
 
-type Function func(ctx context.Context, args []float64) float64
+// Function is a library function. It must return promptly once ctx is done:
+// Parser.Parse waits for it, so a function that ignores ctx can run past any
+// deadline or cancellation.
+type Function func(ctx context.Context, args []float64) (float64, error)
 
 type Library interface {
 	Resolve(funcName string) (Function, bool)
 }
 
+type TypedLibrary interface {
+	Library
+	Signature(funcName string) (Signature, bool)
+	Functions() []Signature
+}
+
 type StdLibrary struct {
 	ref map[string]Function
+	sig map[string]Signature
 }
 
 func NewStdLibrary() *StdLibrary {
 	lib := &StdLibrary{
 		ref: make(map[string]Function),
+		sig: make(map[string]Signature),
 	}
 
-	lib.ref["pow"] = lib.Pow
-	lib.ref["round"] = lib.Round
-	lib.ref["min"] = lib.Min
-	lib.ref["max"] = lib.Max
-	lib.ref["sum"] = lib.Sum
-	lib.ref["avg"] = lib.Avg
-	lib.ref["average"] = lib.Avg
+	lib.Register(Signature{
+		Name:        "pow",
+		Description: "Raises x to the power of y.",
+		Params:      []Param{{Name: "x", Type: TypeNumber}, {Name: "y", Type: TypeNumber}},
+		Result:      TypeNumber,
+		Pure:        true,
+	}, lib.Pow)
+
+	lib.Register(Signature{
+		Name:        "round",
+		Description: "Rounds x to the given number of decimal digits.",
+		Params:      []Param{{Name: "x", Type: TypeNumber}, {Name: "digits", Type: TypeInteger}},
+		Result:      TypeNumber,
+		Pure:        true,
+	}, lib.Round)
+
+	lib.Register(Signature{
+		Name:        "min",
+		Description: "Returns the smallest of the arguments.",
+		Params:      []Param{{Name: "x", Type: TypeNumber}},
+		Result:      TypeNumber,
+		Variadic:    true,
+		Pure:        true,
+	}, lib.Min)
+
+	lib.Register(Signature{
+		Name:        "max",
+		Description: "Returns the largest of the arguments.",
+		Params:      []Param{{Name: "x", Type: TypeNumber}},
+		Result:      TypeNumber,
+		Variadic:    true,
+		Pure:        true,
+	}, lib.Max)
+
+	lib.Register(Signature{
+		Name:        "sum",
+		Description: "Returns the sum of the arguments.",
+		Params:      []Param{{Name: "x", Type: TypeNumber}},
+		Result:      TypeNumber,
+		Variadic:    true,
+		Pure:        true,
+	}, lib.Sum)
+
+	for _, name := range []string{"avg", "average"} {
+		lib.Register(Signature{
+			Name:        name,
+			Description: "Returns the arithmetic mean of the arguments.",
+			Params:      []Param{{Name: "x", Type: TypeNumber}},
+			Result:      TypeNumber,
+			Variadic:    true,
+			Pure:        true,
+		}, lib.Avg)
+	}
 
 	return lib
 }
 
+func (lib *StdLibrary) Register(sig Signature, fn Function) {
+	lib.ref[sig.Name] = fn
+	lib.sig[sig.Name] = sig
+}
+
 func (lib *StdLibrary) Resolve(funcName string) (Function, bool) {
 	if fn, ok := lib.ref[funcName]; ok {
 		return fn, true
@@ -41,51 +102,71 @@ func (lib *StdLibrary) Resolve(funcName string) (Function, bool) {
 	return nil, false
 }
 
-func (lib *StdLibrary) Pow(ctx context.Context, args []float64) float64 {
-	Validate(ctx, "pow", args).ArgLength(2)
+func (lib *StdLibrary) Signature(funcName string) (Signature, bool) {
+	if sig, ok := lib.sig[funcName]; ok {
+		return sig, true
+	}
+	return Signature{}, false
+}
 
-	return math.Pow(args[0], args[1])
+func (lib *StdLibrary) Functions() []Signature {
+	sigs := make([]Signature, 0, len(lib.sig))
+	for _, sig := range lib.sig {
+		sigs = append(sigs, sig)
+	}
+	sort.Slice(sigs, func(i, j int) bool {
+		return sigs[i].Name < sigs[j].Name
+	})
+	return sigs
 }
 
-func (lib *StdLibrary) Round(ctx context.Context, args []float64) float64 {
-	Validate(ctx, "round", args).ArgLength(2)
+func (lib *StdLibrary) Pow(ctx context.Context, args []float64) (float64, error) {
+	x := math.Pow(args[0], args[1])
+	if math.IsNaN(x) && !math.IsNaN(args[0]) && !math.IsNaN(args[1]) {
+		return 0, &DomainError{Func: "pow", Msg: fmt.Sprintf("%v raised to %v is not a real number", args[0], args[1])}
+	}
+	return x, nil
+}
 
+func (lib *StdLibrary) Round(ctx context.Context, args []float64) (float64, error) {
 	fac := math.Pow10(int(args[1]))
-	return math.Round(args[0]*fac) / fac
+	return math.Round(args[0]*fac) / fac, nil
 }
 
-func (lib *StdLibrary) Min(ctx context.Context, args []float64) float64 {
+func (lib *StdLibrary) Min(ctx context.Context, args []float64) (float64, error) {
 	x := math.Inf(1)
 	for _, n := range args {
 		x = math.Min(x, n)
 	}
-	return x
+	return x, nil
 }
 
-func (lib *StdLibrary) Max(ctx context.Context, args []float64) float64 {
+func (lib *StdLibrary) Max(ctx context.Context, args []float64) (float64, error) {
 	x := math.Inf(-1)
 	for _, n := range args {
 		x = math.Max(x, n)
 	}
-	return x
+	return x, nil
 }
 
-func (lib *StdLibrary) Sum(ctx context.Context, args []float64) float64 {
+func (lib *StdLibrary) Sum(ctx context.Context, args []float64) (float64, error) {
 	x := 0.0
 	for _, n := range args {
 		x = x + n
 	}
-	return x
+	return x, nil
 }
 
-func (lib *StdLibrary) Avg(ctx context.Context, args []float64) float64 {
+func (lib *StdLibrary) Avg(ctx context.Context, args []float64) (float64, error) {
 	sum := 0.0
 	for _, n := range args {
 		sum = sum + n
 	}
-	return sum / float64(len(args))
+	return sum / float64(len(args)), nil
 }
 
+// Validator checks the arguments of a call to FuncName. Ctx is the context of
+// the call, for rules that need it.
 type Validator struct {
 	Ctx      context.Context
 	FuncName string
@@ -100,22 +181,39 @@ func Validate(ctx context.Context, funcName string, args []float64) *Validator {
 	}
 }
 
+// Error returns a *ValidationError of msg for the function being validated.
 func (v *Validator) Error(msg string) error {
-	return rdparser.NewParseError(v.Ctx, fmt.Sprintf("[%s] - %s", v.FuncName, msg))
+	return &ValidationError{Func: v.FuncName, Msg: msg}
 }
 
-func (v *Validator) Rule(validatorFunc func(v *Validator)) {
-	validatorFunc(v)
+func (v *Validator) Rule(validatorFunc func(v *Validator) error) error {
+	return validatorFunc(v)
 }
 
-func (v *Validator) ArgLength(n int) {
+func (v *Validator) ArgLength(n int) error {
 	if len(v.Args) != n {
-		panic(v.Error(fmt.Sprintf("expected %d arguments, got %d instead", n, len(v.Args))))
+		return v.Error(fmt.Sprintf("expected %d arguments, got %d instead", n, len(v.Args)))
 	}
+	return nil
 }
 
-func (v *Validator) ArgMinLength(n int) {
+func (v *Validator) ArgMinLength(n int) error {
 	if len(v.Args) < n {
-		panic(v.Error(fmt.Sprintf("expected at least %d arguments, got %d instead", n, len(v.Args))))
+		return v.Error(fmt.Sprintf("expected at least %d arguments, got %d instead", n, len(v.Args)))
+	}
+	return nil
+}
+
+func (v *Validator) Signature(sig Signature) error {
+	if !sig.Accepts(len(v.Args)) {
+		return &ArityError{Func: v.FuncName, Min: sig.MinArity(), Max: sig.MaxArity(), Got: len(v.Args)}
+	}
+
+	for i, arg := range v.Args {
+		if param, ok := sig.Param(i); ok && !param.Type.Accepts(arg) {
+			return &TypeError{Func: v.FuncName, Name: param.Name, Expected: param.Type, Actual: TypeOf(arg)}
+		}
 	}
+
+	return nil
 }
@@ -2,9 +2,12 @@ package formula
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/michaelrk02/rdparser"
 	"github.com/michaelrk02/rdparser/pkg/formula/logic"
@@ -21,96 +24,137 @@ type Parser struct {
 
 	varDict  VariableDict
 	varRegex *regexp.Regexp
+
+	budget Budget
 }
 
-func NewParser(lib Library, epsilon float64, varDict VariableDict) rdparser.Parser {
-	return &Parser{
+func NewParser(lib Library, epsilon float64, varDict VariableDict, opts ...ParserOption) rdparser.Parser {
+	p := &Parser{
 		lib:      lib,
 		epsilon:  epsilon,
 		varDict:  varDict,
 		varRegex: regexp.MustCompile(fmt.Sprintf(`^%s$`, pattern.Variable)),
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
 func (p *Parser) Parse(ctx context.Context, t *rdparser.Tree) (rslt interface{}, err error) {
 	defer rdparser.Catch(rdparser.ErrParse, &err)
+	defer rdparser.Catch(rdparser.ErrRuntime, &err)
 
+	ctx = context.WithValue(ctx, keyEvaluation, &evaluation{})
 	rslt = p.Expr(ctx, t)
 	return
 }
 
 func (p *Parser) Expr(ctx context.Context, t *rdparser.Tree) float64 {
-	ctx = rdparser.Trace(ctx, symbol.Expr)
-
-	term := p.Term(ctx, t.At(0).AssertNonTerminalOf(symbol.Term))
-
-	if t.At(1).AssertNonTerminalOf(symbol.Exprx).Has(2) {
-		op := t.At(1).At(0).AsTerminal()
+	ctx = p.enter(ctx, symbol.Expr, t)
+	ctx, step := explain(ctx, symbol.Expr, t)
+
+	// Expr' nests the rest of the chain on the right, but the operators are
+	// left-associative, so the terms are folded from the left.
+	rslt := p.Term(ctx, t.At(0).AssertNonTerminalOf(symbol.Term))
+	chain := []string{fmt.Sprint(rslt)}
+
+	for rest := t.At(1).AssertNonTerminalOf(symbol.Exprx); rest.Has(2); {
+		op := rest.At(0).AsTerminal()
+		expr := rest.At(1).AssertNonTerminalOf(symbol.Expr)
+		term := p.Term(ctx, expr.At(0).AssertNonTerminalOf(symbol.Term))
 		switch op {
 		case token.Add:
-			term = term + p.Expr(ctx, t.At(1).At(1).AssertNonTerminalOf(symbol.Expr))
+			rslt = rslt + term
 		case token.Sub:
-			term = term - p.Expr(ctx, t.At(1).At(1).AssertNonTerminalOf(symbol.Expr))
+			rslt = rslt - term
 		}
+		chain = append(chain, op.String(), fmt.Sprint(term))
+		rest = expr.At(1).AssertNonTerminalOf(symbol.Exprx)
 	}
 
-	return term
+	if len(chain) > 1 {
+		step.note("%s = %v", strings.Join(chain, " "), rslt)
+	}
+	return step.number(rslt)
 }
 
 func (p *Parser) Term(ctx context.Context, t *rdparser.Tree) float64 {
-	ctx = rdparser.Trace(ctx, symbol.Term)
+	ctx = p.enter(ctx, symbol.Term, t)
+	ctx, step := explain(ctx, symbol.Term, t)
 
-	factor := p.Factor(ctx, t.At(0).AssertNonTerminalOf(symbol.Factor))
+	rslt := p.Factor(ctx, t.At(0).AssertNonTerminalOf(symbol.Factor))
+	chain := []string{fmt.Sprint(rslt)}
 
-	if t.At(1).AssertNonTerminalOf(symbol.Termx).Has(2) {
-		op := t.At(1).At(0).AssertTerminal().AsTerminal()
+	for rest := t.At(1).AssertNonTerminalOf(symbol.Termx); rest.Has(2); {
+		op := rest.At(0).AssertTerminal().AsTerminal()
+		term := rest.At(1).AssertNonTerminalOf(symbol.Term)
+		factor := p.Factor(ctx, term.At(0).AssertNonTerminalOf(symbol.Factor))
 		switch op {
 		case token.Mul:
-			factor = factor * p.Term(ctx, t.At(1).At(1).AssertNonTerminalOf(symbol.Term))
+			rslt = rslt * factor
 		case token.Div:
-			factor = factor / p.Term(ctx, t.At(1).At(1).AssertNonTerminalOf(symbol.Term))
+			if factor == 0 {
+				p.fail(ctx, rest.At(0), &DivisionByZeroError{Op: op})
+			}
+			rslt = rslt / factor
 		case token.Mod:
-			factor = float64(int(factor) % int(p.Term(ctx, t.At(1).At(1).AssertNonTerminalOf(symbol.Term))))
+			if !isFinite(rslt) || !isFinite(factor) {
+				p.fail(ctx, rest.At(0), &DomainError{Func: op.String(), Msg: fmt.Sprintf("cannot take %v modulo %v", rslt, factor)})
+			}
+			if int(factor) == 0 {
+				p.fail(ctx, rest.At(0), &DivisionByZeroError{Op: op})
+			}
+			rslt = float64(int(rslt) % int(factor))
 		}
+		chain = append(chain, op.String(), fmt.Sprint(factor))
+		rest = term.At(1).AssertNonTerminalOf(symbol.Termx)
 	}
 
-	return factor
+	if len(chain) > 1 {
+		step.note("%s = %v", strings.Join(chain, " "), rslt)
+	}
+	return step.number(rslt)
 }
 
 func (p *Parser) Factor(ctx context.Context, t *rdparser.Tree) float64 {
-	ctx = rdparser.Trace(ctx, symbol.Factor)
+	ctx = p.enter(ctx, symbol.Factor, t)
+	ctx, step := explain(ctx, symbol.Factor, t)
 
 	if t.At(0).IsTerminalOf(token.LParen) && t.At(1).IsNonTerminalOf(symbol.Factorx) {
 		if t.At(1).At(0).IsNonTerminalOf(symbol.BoolCond) {
-			return p.BoolCond(ctx, t.At(1).At(0))
+			return step.number(p.BoolCond(ctx, t.At(1).At(0)))
 		}
 
 		if t.At(1).At(0).IsNonTerminalOf(symbol.Expr) {
-			return p.Expr(ctx, t.At(1).At(0))
+			return step.number(p.Expr(ctx, t.At(1).At(0)))
 		}
 	}
 
 	if t.At(0).IsTerminalOf(token.Minus) && t.At(1).IsNonTerminalOf(symbol.Factor) {
-		return -p.Factor(ctx, t.At(1))
+		factor := p.Factor(ctx, t.At(1))
+		step.note("negation of %v", factor)
+		return step.number(-factor)
 	}
 
 	if t.At(0).IsNonTerminalOf(symbol.Variable) {
-		return p.Variable(ctx, t.At(0))
+		return step.number(p.Variable(ctx, t.At(0)))
 	}
 
 	if t.At(0).IsNonTerminalOf(symbol.Number) {
-		return p.Number(ctx, t.At(0))
+		return step.number(p.Number(ctx, t.At(0)))
 	}
 
 	if t.At(0).IsNonTerminalOf(symbol.FuncCall) {
-		return p.FuncCall(ctx, t.At(0))
+		return step.number(p.FuncCall(ctx, t.At(0)))
 	}
 
 	panic(rdparser.NewParseError(ctx, "invalid expression"))
 }
 
 func (p *Parser) FuncCall(ctx context.Context, t *rdparser.Tree) float64 {
-	ctx = rdparser.Trace(ctx, symbol.FuncCall)
+	ctx = p.enter(ctx, symbol.FuncCall, t)
+	ctx, step := explain(ctx, symbol.FuncCall, t)
 
 	funcName := t.At(0).AssertNonTerminalOf(symbol.FuncName).At(0).AsTerminal().String()
 
@@ -121,14 +165,62 @@ func (p *Parser) FuncCall(ctx context.Context, t *rdparser.Tree) float64 {
 	funcArgs := p.FuncArg(ctx, t.At(2))
 
 	if callback, ok := p.lib.Resolve(funcName); ok {
-		return callback(ctx, funcArgs)
+		rslt := p.call(ctx, t, funcName, callback, funcArgs)
+		step.note("%s(%s) = %v", funcName, joinArgs(funcArgs), rslt)
+		return step.number(rslt)
+	}
+
+	p.fail(ctx, t, &UnknownFunctionError{Name: funcName})
+	return 0
+}
+
+func (p *Parser) call(ctx context.Context, t *rdparser.Tree, funcName string, callback Function, args []float64) float64 {
+	fail := func(err error) {
+		var l locatable
+		if !errors.As(err, &l) {
+			err = fmt.Errorf("[%s] - %w", funcName, err)
+		}
+		p.fail(ctx, t, err)
+	}
+
+	p.enterCall(ctx, t, args)
+
+	if typed, ok := p.lib.(TypedLibrary); ok {
+		if sig, ok := typed.Signature(funcName); ok {
+			if err := Validate(ctx, funcName, args).Signature(sig); err != nil {
+				fail(err)
+			}
+		}
 	}
 
-	panic(rdparser.NewParseError(ctx, fmt.Sprintf("unrecognized function `%s`", funcName)))
+	invoke := func() (rslt float64, err error) {
+		defer func() {
+			if v := recover(); v != nil {
+				if e, ok := v.(error); ok {
+					err = e
+				} else {
+					err = fmt.Errorf("panic: %v", v)
+				}
+			}
+		}()
+		return callback(ctx, args)
+	}
+
+	// The evaluation can only stop once the function returns, so a function
+	// that ignores ctx holds Parse up for as long as it runs.
+	rslt, err := invoke()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		p.fail(ctx, t, ctxErr)
+	}
+	if err != nil {
+		fail(err)
+	}
+
+	return rslt
 }
 
 func (p *Parser) FuncArg(ctx context.Context, t *rdparser.Tree) []float64 {
-	ctx = rdparser.Trace(ctx, symbol.FuncArg)
+	ctx = p.enter(ctx, symbol.FuncArg, t)
 
 	if t.Has(2) && t.At(0).IsNonTerminalOf(symbol.Expr) && t.At(1).IsNonTerminalOf(symbol.FuncArgx) {
 		args := []float64{p.Expr(ctx, t.At(0))}
@@ -144,7 +236,8 @@ func (p *Parser) FuncArg(ctx context.Context, t *rdparser.Tree) []float64 {
 }
 
 func (p *Parser) BoolCond(ctx context.Context, t *rdparser.Tree) float64 {
-	ctx = rdparser.Trace(ctx, symbol.BoolCond)
+	ctx = p.enter(ctx, symbol.BoolCond, t)
+	ctx, step := explain(ctx, symbol.BoolCond, t)
 
 	boolExpr := p.BoolExpr(ctx, t.At(0).AssertNonTerminalOf(symbol.BoolExpr))
 	t.At(1).AssertTerminalOf(token.Question)
@@ -153,85 +246,109 @@ func (p *Parser) BoolCond(ctx context.Context, t *rdparser.Tree) float64 {
 	t.At(4).AssertNonTerminalOf(symbol.Expr)
 
 	if boolExpr {
-		return p.Expr(ctx, t.At(2))
+		step.note("condition is true, took `%s`", sourceText(t.At(2)))
+		return step.number(p.Expr(ctx, t.At(2)))
 	} else {
-		return p.Expr(ctx, t.At(4))
+		step.note("condition is false, took `%s`", sourceText(t.At(4)))
+		return step.number(p.Expr(ctx, t.At(4)))
 	}
 }
 
 func (p *Parser) BoolExpr(ctx context.Context, t *rdparser.Tree) bool {
-	ctx = rdparser.Trace(ctx, symbol.BoolExpr)
+	ctx = p.enter(ctx, symbol.BoolExpr, t)
+	ctx, step := explain(ctx, symbol.BoolExpr, t)
 
 	boolTerm := p.BoolTerm(ctx, t.At(0).AssertNonTerminalOf(symbol.BoolTerm))
 
 	if t.At(1).AssertNonTerminalOf(symbol.BoolExprx).Has(2) {
 		t.At(1).At(0).AssertNonTerminalOf(symbol.LogicOr)
-		return boolTerm || p.BoolExpr(ctx, t.At(1).At(1).AssertNonTerminalOf(symbol.BoolExpr))
+		if boolTerm {
+			step.note("left side of or is true, right side skipped")
+			return step.boolean(true)
+		}
+		boolExpr := p.BoolExpr(ctx, t.At(1).At(1).AssertNonTerminalOf(symbol.BoolExpr))
+		step.note("false or %v = %v", boolExpr, boolExpr)
+		return step.boolean(boolExpr)
 	}
 
-	return boolTerm
+	return step.boolean(boolTerm)
 }
 
 func (p *Parser) BoolTerm(ctx context.Context, t *rdparser.Tree) bool {
-	ctx = rdparser.Trace(ctx, symbol.BoolTerm)
+	ctx = p.enter(ctx, symbol.BoolTerm, t)
+	ctx, step := explain(ctx, symbol.BoolTerm, t)
 
 	boolFactor := p.BoolFactor(ctx, t.At(0).AssertNonTerminalOf(symbol.BoolFactor))
 
 	if t.At(1).AssertNonTerminalOf(symbol.BoolTermx).Has(2) {
 		t.At(1).At(0).AssertNonTerminalOf(symbol.LogicAnd)
-		return boolFactor && p.BoolTerm(ctx, t.At(1).At(1).AssertNonTerminalOf(symbol.BoolTerm))
+		if !boolFactor {
+			step.note("left side of and is false, right side skipped")
+			return step.boolean(false)
+		}
+		boolTerm := p.BoolTerm(ctx, t.At(1).At(1).AssertNonTerminalOf(symbol.BoolTerm))
+		step.note("true and %v = %v", boolTerm, boolTerm)
+		return step.boolean(boolTerm)
 	}
 
-	return boolFactor
+	return step.boolean(boolFactor)
 }
 
 func (p *Parser) BoolFactor(ctx context.Context, t *rdparser.Tree) bool {
-	ctx = rdparser.Trace(ctx, symbol.BoolFactor)
+	ctx = p.enter(ctx, symbol.BoolFactor, t)
+	ctx, step := explain(ctx, symbol.BoolFactor, t)
 
 	if t.Has(2) && t.At(0).IsNonTerminalOf(symbol.LogicNot) {
-		return !p.BoolFactor(ctx, t.At(1).AssertNonTerminalOf(symbol.BoolFactor))
+		boolFactor := p.BoolFactor(ctx, t.At(1).AssertNonTerminalOf(symbol.BoolFactor))
+		step.note("not %v = %v", boolFactor, !boolFactor)
+		return step.boolean(!boolFactor)
 	}
 
 	if t.Has(3) && t.At(0).IsTerminalOf(token.LParen) {
 		t.At(2).AssertTerminalOf(token.RParen)
 
-		return p.BoolExpr(ctx, t.At(1).AssertNonTerminalOf(symbol.BoolExpr))
+		return step.boolean(p.BoolExpr(ctx, t.At(1).AssertNonTerminalOf(symbol.BoolExpr)))
 	}
 
 	if t.Has(1) && t.At(0).IsNonTerminalOf(symbol.LogicExpr) {
-		return p.LogicExpr(ctx, t.At(0))
+		return step.boolean(p.LogicExpr(ctx, t.At(0)))
 	}
 
 	panic(rdparser.NewParseError(ctx, "invalid expression"))
 }
 
 func (p *Parser) LogicExpr(ctx context.Context, t *rdparser.Tree) bool {
-	ctx = rdparser.Trace(ctx, symbol.LogicExpr)
+	ctx = p.enter(ctx, symbol.LogicExpr, t)
+	ctx, step := explain(ctx, symbol.LogicExpr, t)
 
 	exprA := p.Expr(ctx, t.At(0).AssertNonTerminalOf(symbol.Expr))
 	logicOp := p.LogicOp(ctx, t.At(1).AssertNonTerminalOf(symbol.LogicOp))
 	exprB := p.Expr(ctx, t.At(2).AssertNonTerminalOf(symbol.Expr))
 
+	var rslt bool
 	switch logicOp {
 	case LogicOpEqu:
-		return logic.Equ(exprA, exprB, p.epsilon)
+		rslt = logic.Equ(exprA, exprB, p.epsilon)
 	case LogicOpNotEqu:
-		return logic.NotEqu(exprA, exprB, p.epsilon)
+		rslt = logic.NotEqu(exprA, exprB, p.epsilon)
 	case LogicOpLTEqu:
-		return logic.LTEqu(exprA, exprB, p.epsilon)
+		rslt = logic.LTEqu(exprA, exprB, p.epsilon)
 	case LogicOpGTEqu:
-		return logic.GTEqu(exprA, exprB, p.epsilon)
+		rslt = logic.GTEqu(exprA, exprB, p.epsilon)
 	case LogicOpLT:
-		return logic.LT(exprA, exprB)
+		rslt = logic.LT(exprA, exprB)
 	case LogicOpGT:
-		return logic.GT(exprA, exprB)
+		rslt = logic.GT(exprA, exprB)
+	default:
+		panic(rdparser.NewParseError(ctx, "invalid logical op code"))
 	}
 
-	panic(rdparser.NewParseError(ctx, "invalid logical op code"))
+	step.note("%v %s %v is %v", exprA, t.At(1).At(0).AsTerminal(), exprB, rslt)
+	return step.boolean(rslt)
 }
 
 func (p *Parser) LogicOp(ctx context.Context, t *rdparser.Tree) LogicOp {
-	ctx = rdparser.Trace(ctx, symbol.LogicOp)
+	ctx = p.enter(ctx, symbol.LogicOp, t)
 
 	op := t.At(0).AsTerminal()
 	switch op {
@@ -253,7 +370,8 @@ func (p *Parser) LogicOp(ctx context.Context, t *rdparser.Tree) LogicOp {
 }
 
 func (p *Parser) Variable(ctx context.Context, t *rdparser.Tree) float64 {
-	ctx = rdparser.Trace(ctx, symbol.Variable)
+	ctx = p.enter(ctx, symbol.Variable, t)
+	ctx, step := explain(ctx, symbol.Variable, t)
 
 	varToken := t.At(0).AsTerminal().String()
 
@@ -264,14 +382,17 @@ func (p *Parser) Variable(ctx context.Context, t *rdparser.Tree) float64 {
 	varName := varExtract[1]
 
 	if rslt, ok := p.varDict[varName]; ok {
-		return rslt
+		step.note("variable `%s`", varName)
+		return step.number(rslt)
 	}
 
-	panic(rdparser.NewParseError(ctx, fmt.Sprintf("unknown variable `%s`", varName)))
+	p.fail(ctx, t, &UnknownVariableError{Name: varName})
+	return 0
 }
 
 func (p *Parser) Number(ctx context.Context, t *rdparser.Tree) float64 {
-	ctx = rdparser.Trace(ctx, symbol.Number)
+	ctx = p.enter(ctx, symbol.Number, t)
+	_, step := explain(ctx, symbol.Number, t)
 
 	numToken := t.At(0).AsTerminal().String()
 	rslt, err := strconv.ParseFloat(numToken, 64)
@@ -279,5 +400,17 @@ func (p *Parser) Number(ctx context.Context, t *rdparser.Tree) float64 {
 		panic(rdparser.NewParseError(ctx, fmt.Sprintf("error parsing number `%s`", numToken)))
 	}
 
-	return rslt
+	return step.number(rslt)
+}
+
+func (p *Parser) fail(ctx context.Context, t *rdparser.Tree, err error) {
+	var l locatable
+	if errors.As(err, &l) {
+		l.locate(t.Pos())
+	}
+	panic(rdparser.WrapRuntimeError(ctx, t.Pos(), err))
+}
+
+func isFinite(x float64) bool {
+	return !math.IsInf(x, 0) && !math.IsNaN(x)
 }
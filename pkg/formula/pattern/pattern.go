@@ -4,6 +4,8 @@ const (
 	Number   string = `([0-9]+(\.[0-9]+)?(e[\+-][0-9]+)?)`
 	Function string = `([a-zA-Z][a-zA-Z0-9]*)`
 	Variable string = `\[([a-zA-Z0-9-:]+)\]`
+
+	Comment string = `(/\*[\s\S]*?\*/|#[^\n]*)`
 )
 
 func Dict() []string {
@@ -0,0 +1,74 @@
+package formula
+
+import "fmt"
+
+type Param struct {
+	Name     string
+	Type     Type
+	Optional bool
+}
+
+// Signature describes a library function. Its arity follows from Params
+// alone: every parameter that is not Optional is required, and when Variadic
+// is set the last parameter may be repeated; it may also be omitted if it is
+// Optional.
+type Signature struct {
+	Name        string
+	Description string
+	Params      []Param
+	Result      Type
+	Variadic    bool
+	Pure        bool
+}
+
+func (sig Signature) MinArity() int {
+	n := 0
+	for _, param := range sig.Params {
+		if !param.Optional {
+			n++
+		}
+	}
+	return n
+}
+
+func (sig Signature) MaxArity() int {
+	if sig.Variadic {
+		return -1
+	}
+	return len(sig.Params)
+}
+
+func (sig Signature) Accepts(n int) bool {
+	return n >= sig.MinArity() && (sig.MaxArity() < 0 || n <= sig.MaxArity())
+}
+
+func (sig Signature) Param(i int) (Param, bool) {
+	if i < len(sig.Params) {
+		return sig.Params[i], true
+	}
+	if sig.Variadic && len(sig.Params) > 0 {
+		return sig.Params[len(sig.Params)-1], true
+	}
+	return Param{}, false
+}
+
+func (sig Signature) ArityString() string {
+	return arityString(sig.MinArity(), sig.MaxArity())
+}
+
+func (sig Signature) String() string {
+	params := ""
+	for i, param := range sig.Params {
+		if i > 0 {
+			params += ", "
+		}
+		params += fmt.Sprintf("%s %s", param.Name, param.Type)
+		if param.Optional {
+			params += "?"
+		}
+	}
+	if sig.Variadic {
+		params += "..."
+	}
+	return fmt.Sprintf("%s(%s) %s", sig.Name, params, sig.Result)
+}
@@ -0,0 +1,45 @@
+package formula
+
+import "math"
+
+type Type int
+
+const (
+	TypeNumber Type = iota
+	TypeInteger
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeNumber:
+		return "number"
+	case TypeInteger:
+		return "integer"
+	}
+	return "unknown"
+}
+
+func (t Type) AssignableTo(u Type) bool {
+	return t == u || (t == TypeInteger && u == TypeNumber)
+}
+
+func (t Type) Accepts(x float64) bool {
+	if t == TypeInteger {
+		return !math.IsInf(x, 0) && x == math.Trunc(x)
+	}
+	return true
+}
+
+func TypeOf(x float64) Type {
+	if TypeInteger.Accepts(x) {
+		return TypeInteger
+	}
+	return TypeNumber
+}
+
+func joinType(a, b Type) Type {
+	if a == TypeInteger && b == TypeInteger {
+		return TypeInteger
+	}
+	return TypeNumber
+}
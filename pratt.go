@@ -0,0 +1,152 @@
+package rdparser
+
+import (
+	"context"
+
+	"github.com/shivamMg/rd"
+)
+
+type Assoc int
+
+const (
+	AssocLeft Assoc = iota
+	AssocRight
+	AssocNone
+)
+
+type operator struct {
+	sym    NonTerminal
+	bp     int
+	assoc  Assoc
+	second Terminal
+}
+
+// Operators is an operator-precedence (Pratt) table. Binding powers must be
+// positive; higher powers bind tighter. Every operator application becomes a
+// node of its registered symbol:
+//
+//	prefix:  Sym -> op Operand
+//	infix:   Sym -> Operand op Operand
+//	postfix: Sym -> Operand op
+//	ternary: Sym -> Operand op Operand op2 Operand
+type Operators struct {
+	prefix  map[Terminal]operator
+	infix   map[Terminal]operator
+	postfix map[Terminal]operator
+	ternary map[Terminal]operator
+}
+
+func NewOperators() *Operators {
+	return &Operators{
+		prefix:  make(map[Terminal]operator),
+		infix:   make(map[Terminal]operator),
+		postfix: make(map[Terminal]operator),
+		ternary: make(map[Terminal]operator),
+	}
+}
+
+func (ops *Operators) Prefix(op Terminal, bp int, sym NonTerminal) *Operators {
+	ops.prefix[op] = operator{sym: sym, bp: bp}
+	return ops
+}
+
+func (ops *Operators) Infix(op Terminal, bp int, assoc Assoc, sym NonTerminal) *Operators {
+	ops.infix[op] = operator{sym: sym, bp: bp, assoc: assoc}
+	return ops
+}
+
+func (ops *Operators) Postfix(op Terminal, bp int, sym NonTerminal) *Operators {
+	ops.postfix[op] = operator{sym: sym, bp: bp}
+	return ops
+}
+
+func (ops *Operators) Ternary(op, second Terminal, bp int, sym NonTerminal) *Operators {
+	ops.ternary[op] = operator{sym: sym, bp: bp, assoc: AssocRight, second: second}
+	return ops
+}
+
+// Parse parses an operator expression into the current non-terminal, using
+// operand to parse the atoms between operators. On failure the caller should
+// Backtrack or fail the enclosing rule.
+func (ops *Operators) Parse(ctx context.Context, b *Builder, operand func(ctx context.Context, b *Builder) bool) bool {
+	return ops.parse(ctx, b, operand, 0)
+}
+
+func (ops *Operators) parse(ctx context.Context, b *Builder, operand func(ctx context.Context, b *Builder) bool, minBP int) bool {
+	mark := len(b.frame().node.Subtrees)
+
+	if op, ok := ops.lookup(b, ops.prefix); ok {
+		b.Match(op.tok)
+		if !ops.parse(ctx, b, operand, op.bp) {
+			return false
+		}
+		b.wrap(mark, op.sym)
+	} else if !operand(ctx, b) {
+		return false
+	}
+
+	nonAssocBP := -1
+	for {
+		if op, ok := ops.lookup(b, ops.postfix); ok && op.bp > minBP {
+			b.Match(op.tok)
+			b.wrap(mark, op.sym)
+			continue
+		}
+
+		if op, ok := ops.lookup(b, ops.ternary); ok && op.bp > minBP {
+			b.Match(op.tok)
+			if !ops.parse(ctx, b, operand, 0) || !b.Match(op.second) || !ops.parse(ctx, b, operand, op.bp-1) {
+				return false
+			}
+			b.wrap(mark, op.sym)
+			continue
+		}
+
+		op, ok := ops.lookup(b, ops.infix)
+		if !ok || op.bp <= minBP {
+			return true
+		}
+		if op.bp == nonAssocBP {
+			return false
+		}
+
+		b.Match(op.tok)
+		rbp := op.bp
+		if op.assoc == AssocRight {
+			rbp--
+		}
+		if !ops.parse(ctx, b, operand, rbp) {
+			return false
+		}
+		b.wrap(mark, op.sym)
+
+		nonAssocBP = -1
+		if op.assoc == AssocNone {
+			nonAssocBP = op.bp
+		}
+	}
+}
+
+type operatorMatch struct {
+	operator
+	tok Terminal
+}
+
+func (ops *Operators) lookup(b *Builder, table map[Terminal]operator) (operatorMatch, bool) {
+	next, ok := b.Peek(1)
+	if !ok {
+		return operatorMatch{}, false
+	}
+
+	sym := terminalOf(next)
+	op, ok := table[sym]
+	return operatorMatch{operator: op, tok: sym}, ok
+}
+
+func (b *Builder) wrap(from int, sym NonTerminal) {
+	f := b.frame()
+
+	node := rd.NewTree(sym)
+	node.Subtrees = append([]*rd.Tree{}, f.node.Subtrees[from:]...)
+	f.node.Subtrees = append(f.node.Subtrees[:from], node)
+}
@@ -0,0 +1,336 @@
+package rdparser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/shivamMg/rd"
+)
+
+var ErrQuery = fmt.Errorf("query error")
+
+// Query is a CSS-like selector over parse trees:
+//
+//	FuncCall                       every FuncCall node
+//	BoolCond LogicExpr             LogicExpr nodes anywhere under a BoolCond
+//	Factor > "-"                   "-" terminals that are children of a Factor
+//	FuncCall[> FuncName > "round"] calls whose own FuncName is "round"
+//	Factor[!Factor'], Number       predicates may be negated, selectors joined
+//
+// Names match non-terminals, quoted strings match terminals and `*` matches
+// any node. A predicate holds if its selector matches below the node; a
+// leading `>` restricts it to the node's children.
+type Query struct {
+	selectors []*selector
+}
+
+type combinator int
+
+const (
+	descendant combinator = iota
+	child
+)
+
+type selector struct {
+	steps []*step
+}
+
+type step struct {
+	comb     combinator
+	any      bool
+	name     string
+	terminal bool
+	preds    []predicate
+}
+
+type predicate struct {
+	not   bool
+	query *Query
+}
+
+type Match struct {
+	Tree *Tree
+	Pos  Position
+}
+
+func ParseQuery(src string) (*Query, error) {
+	p := &queryParser{src: []rune(src)}
+	q, err := p.query()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.i < len(p.src) {
+		return nil, p.fail("unexpected character")
+	}
+	return q, nil
+}
+
+func MustParseQuery(src string) *Query {
+	q, err := ParseQuery(src)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+func (t *Tree) Query(src string) ([]Match, error) {
+	q, err := ParseQuery(src)
+	if err != nil {
+		return nil, err
+	}
+	return q.Select(t), nil
+}
+
+// Select returns the matching subtrees of t in document order.
+func (q *Query) Select(t *Tree) []Match {
+	matches := []Match{}
+	q.each(t.Tree, []*rd.Tree{nil}, func(node *rd.Tree) bool {
+		sub := &Tree{Tree: node}
+		matches = append(matches, Match{Tree: sub, Pos: sub.Pos()})
+		return true
+	})
+	return matches
+}
+
+// each calls fn for every node under and including t that matches, where anc
+// holds the ancestors of t starting with the query context.
+func (q *Query) each(t *rd.Tree, anc []*rd.Tree, fn func(node *rd.Tree) bool) bool {
+	for _, sel := range q.selectors {
+		if sel.match(len(sel.steps)-1, t, anc) {
+			if !fn(t) {
+				return false
+			}
+			break
+		}
+	}
+
+	anc = append(anc, t)
+	for _, sub := range t.Subtrees {
+		if !q.each(sub, anc, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+func (q *Query) exists(context *rd.Tree) bool {
+	found := false
+	for _, sub := range context.Subtrees {
+		q.each(sub, []*rd.Tree{context}, func(node *rd.Tree) bool {
+			found = true
+			return false
+		})
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+func (sel *selector) match(i int, t *rd.Tree, anc []*rd.Tree) bool {
+	s := sel.steps[i]
+	if !s.matches(t) {
+		return false
+	}
+
+	if i == 0 {
+		return s.comb == descendant || len(anc) == 1
+	}
+
+	if s.comb == child {
+		return len(anc) > 1 && sel.match(i-1, anc[len(anc)-1], anc[:len(anc)-1])
+	}
+
+	for j := len(anc) - 1; j >= 1; j-- {
+		if sel.match(i-1, anc[j], anc[:j]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *step) matches(t *rd.Tree) bool {
+	switch {
+	case s.any:
+	case s.terminal:
+		if !IsTerminalOf(t.Symbol, Terminal(s.name)) {
+			return false
+		}
+	default:
+		if !IsNonTerminalOf(t.Symbol, NonTerminal(s.name)) {
+			return false
+		}
+	}
+
+	for _, pred := range s.preds {
+		if pred.query.exists(t) == pred.not {
+			return false
+		}
+	}
+	return true
+}
+
+type queryParser struct {
+	src []rune
+	i   int
+}
+
+func (p *queryParser) fail(msg string) error {
+	return NewError(ErrQuery, fmt.Sprintf("%s at column %d", msg, p.i+1))
+}
+
+func (p *queryParser) skipSpace() {
+	for p.i < len(p.src) && unicode.IsSpace(p.src[p.i]) {
+		p.i++
+	}
+}
+
+func (p *queryParser) peek() rune {
+	p.skipSpace()
+	if p.i < len(p.src) {
+		return p.src[p.i]
+	}
+	return 0
+}
+
+func (p *queryParser) query() (*Query, error) {
+	q := &Query{}
+	for {
+		sel, err := p.selector()
+		if err != nil {
+			return nil, err
+		}
+		q.selectors = append(q.selectors, sel)
+
+		if p.peek() != ',' {
+			return q, nil
+		}
+		p.i++
+	}
+}
+
+func (p *queryParser) selector() (*selector, error) {
+	sel := &selector{}
+	for {
+		comb := descendant
+		if p.peek() == '>' {
+			comb = child
+			p.i++
+		}
+
+		s, err := p.step()
+		if err != nil {
+			return nil, err
+		}
+		s.comb = comb
+		sel.steps = append(sel.steps, s)
+
+		if r := p.peek(); r == 0 || r == ',' || r == ']' {
+			return sel, nil
+		}
+	}
+}
+
+func (p *queryParser) step() (*step, error) {
+	s := &step{}
+
+	switch r := p.peek(); {
+	case r == '*':
+		s.any = true
+		p.i++
+
+	case r == '"' || r == '\'':
+		end := p.i + 1
+		for end < len(p.src) && p.src[end] != r {
+			if p.src[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(p.src) {
+			return nil, p.fail("unterminated string")
+		}
+		lit := string(p.src[p.i : end+1])
+		if r == '\'' {
+			lit = doubleQuote(p.src[p.i+1 : end])
+		}
+		name, err := strconv.Unquote(lit)
+		if err != nil {
+			return nil, p.fail("invalid string")
+		}
+		s.name, s.terminal = name, true
+		p.i = end + 1
+
+	case r == '<':
+		end := p.i
+		for end < len(p.src) && p.src[end] != '>' {
+			end++
+		}
+		if end >= len(p.src) {
+			return nil, p.fail("unterminated symbol")
+		}
+		s.name = string(p.src[p.i : end+1])
+		p.i = end + 1
+
+	case r == '_' || unicode.IsLetter(r):
+		start := p.i
+		for p.i < len(p.src) && isIdentRune(p.src[p.i], false) {
+			p.i++
+		}
+		s.name = string(p.src[start:p.i])
+
+	default:
+		return nil, p.fail("expecting symbol")
+	}
+
+	for p.i < len(p.src) && p.src[p.i] == '[' {
+		p.i++
+
+		pred := predicate{}
+		if p.peek() == '!' {
+			pred.not = true
+			p.i++
+		}
+
+		q, err := p.query()
+		if err != nil {
+			return nil, err
+		}
+		pred.query = q
+
+		if p.peek() != ']' {
+			return nil, p.fail("expecting `]`")
+		}
+		p.i++
+		s.preds = append(s.preds, pred)
+	}
+
+	return s, nil
+}
+
+// doubleQuote rewrites the body of a single-quoted string as a double-quoted
+// Go literal: `\'` loses its backslash, which strconv.Unquote would reject,
+// and bare `"` gains one.
+func doubleQuote(body []rune) string {
+	sb := &strings.Builder{}
+	sb.WriteByte('"')
+	for i := 0; i < len(body); i++ {
+		switch {
+		case body[i] == '\\' && i+1 < len(body) && body[i+1] == '\'':
+			sb.WriteRune('\'')
+			i++
+		case body[i] == '\\' && i+1 < len(body):
+			sb.WriteRune(body[i])
+			sb.WriteRune(body[i+1])
+			i++
+		case body[i] == '"':
+			sb.WriteString(`\"`)
+		default:
+			sb.WriteRune(body[i])
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
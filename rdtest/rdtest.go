@@ -0,0 +1,340 @@
+// Package rdtest runs golden table files through a lexer, grammar and parser.
+//
+// A table file holds cases like the following, where the input is every line
+// up to the first section:
+//
+//	=== subtraction
+//	3 - 1
+//	--- tree
+//	Expr
+//	  Term
+//	    Factor
+//	      Number
+//	        "3"
+//	...
+//	--- value
+//	2
+//
+//	=== division by zero
+//	1 / 0
+//	--- error
+//	runtime error (formula.DivisionByZeroError) at 1:3
+//
+// The tree section is only checked when present. With Pipeline.Update set, Run
+// rewrites the file with the actual results instead; cases without any section
+// get all of them. rdtest registers no flags, so a test package usually wires
+// Update to its own -update flag.
+package rdtest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/michaelrk02/rdparser"
+)
+
+// Section names of a case.
+const (
+	SectionTree  = "tree"
+	SectionValue = "value"
+	SectionError = "error"
+)
+
+// Pipeline is what a table runs its inputs through. Parser may be nil for
+// grammars that only build trees. Update makes Run rewrite the table file
+// rather than check it.
+type Pipeline struct {
+	Lexer   rdparser.Lexer
+	Grammar rdparser.Grammar
+	Parser  rdparser.Parser
+	Options []rdparser.Option
+	Update  bool
+}
+
+// Case is an input of a table with its expected sections, keyed by section
+// name and kept in the order of the file.
+type Case struct {
+	Name     string
+	Input    string
+	Sections map[string]string
+	order    []string
+}
+
+// Table is a parsed table file. Header holds the lines before the first case.
+type Table struct {
+	Header string
+	Cases  []*Case
+}
+
+// Run checks every case of the table file at path as a subtest of t.
+func Run(t *testing.T, path string, p Pipeline) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := ParseTable(string(data))
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+
+	for _, c := range table.Cases {
+		actual := p.Run(c.Input)
+
+		if p.Update {
+			c.Sections = map[string]string{}
+			keepTree := len(c.order) == 0
+			for _, name := range c.order {
+				keepTree = keepTree || name == SectionTree
+			}
+			c.order = nil
+			for _, name := range []string{SectionTree, SectionValue, SectionError} {
+				if v, ok := actual[name]; ok && (name != SectionTree || keepTree) {
+					c.Set(name, v)
+				}
+			}
+			continue
+		}
+
+		t.Run(c.Name, func(t *testing.T) {
+			for _, name := range []string{SectionTree, SectionValue, SectionError} {
+				expected, ok := c.Sections[name]
+				got, gotOK := actual[name]
+				if name == SectionTree && !ok {
+					continue
+				}
+				if ok != gotOK || expected != got {
+					t.Errorf("%s:\n--- expected\n%s\n--- actual\n%s", name, orNone(expected, ok), orNone(got, gotOK))
+				}
+			}
+		})
+	}
+
+	if p.Update {
+		if err := os.WriteFile(path, []byte(table.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Run returns the sections input produces: the tree once it compiles, then
+// either the value or the error.
+func (p Pipeline) Run(input string) map[string]string {
+	sections := map[string]string{}
+
+	fail := func(err error) map[string]string {
+		sections[SectionError] = FormatError(err)
+		return sections
+	}
+
+	tokens, err := p.Lexer.Lex(input)
+	if err != nil {
+		return fail(err)
+	}
+
+	tree, err := rdparser.Compile(tokens, p.Grammar, p.Options...)
+	if err != nil {
+		return fail(err)
+	}
+	sections[SectionTree] = FormatTree(tree)
+
+	if p.Parser == nil {
+		return sections
+	}
+
+	rslt, err := p.Parser.Parse(context.Background(), tree)
+	if err != nil {
+		return fail(err)
+	}
+	sections[SectionValue] = fmt.Sprint(rslt)
+	return sections
+}
+
+// FormatTree writes one node per line, indented by depth, with terminals
+// quoted.
+func FormatTree(t *rdparser.Tree) string {
+	lines := []string{}
+
+	var write func(t *rdparser.Tree, depth int)
+	write = func(t *rdparser.Tree, depth int) {
+		indent := strings.Repeat("  ", depth)
+		if t.IsTerminal() {
+			lines = append(lines, indent+strconv.Quote(t.AsToken().Text))
+			return
+		}
+		lines = append(lines, indent+t.AsNonTerminal().String())
+		for i := 0; i < t.Len(); i++ {
+			write(t.At(i), depth+1)
+		}
+	}
+	write(t, 0)
+
+	return strings.Join(lines, "\n")
+}
+
+var errorKinds = []error{
+	rdparser.ErrLimit,
+	rdparser.ErrLexical,
+	rdparser.ErrCompile,
+	rdparser.ErrParse,
+	rdparser.ErrRuntime,
+}
+
+// FormatError gives the kind of err, the type of the error behind a runtime
+// error and, if known, the position, e.g.
+// `runtime error (formula.DivisionByZeroError) at 1:5`. Causes made with
+// errors.New, fmt.Errorf or rdparser.NewError have no type worth recording.
+func FormatError(err error) string {
+	kind := "error"
+	for _, k := range errorKinds {
+		if errors.Is(err, k) {
+			kind = k.Error()
+			break
+		}
+	}
+
+	var pos rdparser.Position
+	var syntaxErr *rdparser.SyntaxError
+	var runtimeErr *rdparser.RuntimeError
+	var limitErr *rdparser.LimitError
+	switch {
+	case errors.As(err, &limitErr):
+		pos = limitErr.Pos
+	case errors.As(err, &syntaxErr):
+		pos = syntaxErr.Pos
+	case errors.As(err, &runtimeErr):
+		pos = runtimeErr.Pos
+		if name := typeName(runtimeErr.Err); name != "" {
+			kind = fmt.Sprintf("%s (%s)", kind, name)
+		}
+	}
+
+	if pos.IsValid() {
+		return fmt.Sprintf("%s at %s", kind, pos)
+	}
+	return kind
+}
+
+// typeName names the type of err, looking through the wrappers of the
+// standard library.
+func typeName(err error) string {
+	for err != nil {
+		typ := reflect.TypeOf(err)
+		if typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+
+		switch {
+		case typ == reflect.TypeOf(rdparser.Error{}):
+			return ""
+		case typ.PkgPath() == "errors" || typ.PkgPath() == "fmt":
+			err = errors.Unwrap(err)
+		default:
+			return typ.String()
+		}
+	}
+	return ""
+}
+
+// ParseTable reads a table file, failing on unknown or duplicate sections.
+func ParseTable(src string) (*Table, error) {
+	table := &Table{}
+	header := []string{}
+
+	var c *Case
+	var section string
+	var lines []string
+
+	flush := func() {
+		text := strings.Join(lines, "\n")
+		if section == "" {
+			c.Input = strings.TrimRight(text, "\n")
+		} else {
+			c.Set(section, text)
+		}
+		lines = nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(src))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+
+		switch {
+		case strings.HasPrefix(line, "=== "):
+			if c != nil {
+				flush()
+			}
+			c = &Case{Name: strings.TrimSpace(line[4:]), Sections: map[string]string{}}
+			table.Cases = append(table.Cases, c)
+			section = ""
+
+		case c == nil:
+			header = append(header, line)
+
+		case strings.HasPrefix(line, "--- "):
+			flush()
+			section = strings.TrimSpace(line[4:])
+			if section != SectionTree && section != SectionValue && section != SectionError {
+				return nil, fmt.Errorf("line %d: unknown section `%s`", n, section)
+			}
+			if _, ok := c.Sections[section]; ok {
+				return nil, fmt.Errorf("line %d: duplicate section `%s`", n, section)
+			}
+
+		default:
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if c != nil {
+		flush()
+	}
+
+	table.Header = strings.Join(header, "\n")
+	return table, nil
+}
+
+// Set replaces or appends a section of c.
+func (c *Case) Set(section, text string) {
+	if _, ok := c.Sections[section]; !ok {
+		c.order = append(c.order, section)
+	}
+	c.Sections[section] = strings.TrimRight(text, "\n")
+}
+
+func (table *Table) String() string {
+	sb := &strings.Builder{}
+	if table.Header != "" {
+		sb.WriteString(table.Header + "\n")
+	}
+
+	for i, c := range table.Cases {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(sb, "=== %s\n%s\n", c.Name, c.Input)
+		for _, name := range c.order {
+			fmt.Fprintf(sb, "--- %s\n%s\n", name, c.Sections[name])
+		}
+	}
+
+	return sb.String()
+}
+
+func orNone(s string, ok bool) string {
+	if !ok {
+		return "(none)"
+	}
+	return s
+}
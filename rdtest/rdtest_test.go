@@ -0,0 +1,70 @@
+package rdtest
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"testing"
+
+	"github.com/michaelrk02/rdparser"
+)
+
+func TestTable(t *testing.T) {
+	src := `# header
+
+=== first
+1 + 2
+--- value
+3
+
+=== second
+a
+b
+--- tree
+X
+  "a"
+--- error
+compile error at 2:1
+`
+
+	table, err := ParseTable(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(table.Cases) != 2 || table.Cases[1].Input != "a\nb" || table.Cases[1].Sections[SectionTree] != "X\n  \"a\"" {
+		t.Errorf("unexpected table %#v", table.Cases)
+	}
+	if actual := table.String(); actual != src {
+		t.Errorf("table does not round-trip:\n%s", actual)
+	}
+
+	if _, err := ParseTable("=== x\n1\n--- values\n1\n"); err == nil {
+		t.Errorf("expected unknown section error")
+	}
+
+	err = fmt.Errorf("wrapped: %w", &rdparser.SyntaxError{Pos: rdparser.Position{Line: 1, Column: 4}, Msg: "oops"})
+	if actual := FormatError(err); actual != "compile error at 1:4" {
+		t.Errorf("unexpected error format %q", actual)
+	}
+
+	err = rdparser.WrapRuntimeError(context.Background(), rdparser.Position{Line: 1, Column: 3}, fmt.Errorf("[f] - %w", &testError{}))
+	if actual := FormatError(err); actual != "runtime error (rdtest.testError) at 1:3" {
+		t.Errorf("unexpected error format %q", actual)
+	}
+	err = rdparser.WrapRuntimeError(context.Background(), rdparser.Position{Line: 1, Column: 3}, rdparser.NewRuntimeError("oops"))
+	if actual := FormatError(err); actual != "runtime error at 1:3" {
+		t.Errorf("unexpected error format %q", actual)
+	}
+
+	// Importing rdtest must leave -update free for the test package.
+	if flag.Lookup("update") != nil {
+		t.Errorf("rdtest registers an -update flag")
+	}
+}
+
+type testError struct{}
+
+func (err *testError) Error() string {
+	return "test error"
+}
@@ -16,14 +16,47 @@ func NewRuntimeError(msg string) error {
 	return NewError(ErrRuntime, msg)
 }
 
+type RuntimeError struct {
+	Pos        Position
+	StackTrace StackTrace
+	Err        error
+}
+
+func WrapRuntimeError(ctx context.Context, pos Position, err error) error {
+	return &RuntimeError{Pos: pos, StackTrace: GetStackTrace(ctx), Err: err}
+}
+
+func (err *RuntimeError) Error() string {
+	return fmt.Sprintf("%s - %s (at %s, stacktrace = %s)", ErrRuntime, err.Err, err.Pos, err.StackTrace)
+}
+
+func (err *RuntimeError) Unwrap() []error {
+	return []error{ErrRuntime, err.Err}
+}
+
+// traceFrame links each traced symbol to its caller so that Trace takes
+// constant time however deep the recursion goes.
+type traceFrame struct {
+	parent *traceFrame
+	symbol NonTerminal
+	depth  int
+}
+
 func Trace(ctx context.Context, symbol NonTerminal) context.Context {
-	newStackTrace := StackTrace{
-		Path: append(GetStackTrace(ctx).Path, StackTraceElement{
-			Symbol: symbol,
-			Data:   "",
-		}),
+	f := &traceFrame{symbol: symbol, depth: 1}
+	if parent, ok := ctx.Value(keyStackTrace).(*traceFrame); ok {
+		f.parent = parent
+		f.depth = parent.depth + 1
+	}
+	return context.WithValue(ctx, keyStackTrace, f)
+}
+
+// TraceDepth returns the number of symbols traced in ctx.
+func TraceDepth(ctx context.Context) int {
+	if f, ok := ctx.Value(keyStackTrace).(*traceFrame); ok {
+		return f.depth
 	}
-	return context.WithValue(ctx, keyStackTrace, newStackTrace)
+	return 0
 }
 
 type StackTrace struct {
@@ -36,12 +69,16 @@ type StackTraceElement struct {
 }
 
 func GetStackTrace(ctx context.Context) StackTrace {
-	if v := ctx.Value(keyStackTrace); v != nil {
-		if st, ok := v.(StackTrace); ok {
-			return st
-		}
+	f, _ := ctx.Value(keyStackTrace).(*traceFrame)
+	if f == nil {
+		return StackTrace{Path: []StackTraceElement{}}
+	}
+
+	path := make([]StackTraceElement, f.depth)
+	for ; f != nil; f = f.parent {
+		path[f.depth-1] = StackTraceElement{Symbol: f.symbol, Data: ""}
 	}
-	return StackTrace{Path: []StackTraceElement{}}
+	return StackTrace{Path: path}
 }
 
 func (st StackTrace) Lookup(sym NonTerminal) (bool, int) {
@@ -27,14 +27,14 @@ func (t NonTerminal) String() string {
 }
 
 func IsTerminal(v interface{}) bool {
-	if _, ok := v.(Terminal); ok {
+	if _, ok := TerminalOf(v); ok {
 		return true
 	}
 	return false
 }
 
 func IsTerminalOf(v interface{}, sym Terminal) bool {
-	if test, ok := v.(Terminal); ok && test == sym {
+	if test, ok := TerminalOf(v); ok && test == sym {
 		return true
 	}
 	return false
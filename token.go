@@ -0,0 +1,63 @@
+package rdparser
+
+import (
+	"fmt"
+
+	"github.com/shivamMg/rd"
+)
+
+type Position struct {
+	Offset int `json:"offset"`
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Token is a terminal with its source text. Leading holds the whitespace and
+// comments before it and Trailing whatever follows the last token of the
+// input, so that Tree.Source can reproduce the input exactly.
+type Token struct {
+	Terminal Terminal
+	Text     string
+	Pos      Position
+
+	Leading  string
+	Trailing string
+}
+
+// EOF is the terminal of the empty token that a lexer returns for input made
+// only of whitespace and comments, with them as its Leading trivia. Compile
+// does not parse it: failures are reported at its position, and a tree gets it
+// as its last leaf so that Tree.Source still reproduces the input.
+const EOF Terminal = "<EOF>"
+
+func (t Token) String() string {
+	return t.Terminal.String()
+}
+
+func TerminalOf(tok rd.Token) (Terminal, bool) {
+	switch v := tok.(type) {
+	case Terminal:
+		return v, true
+	case Token:
+		return v.Terminal, true
+	}
+	return "", false
+}
+
+func PositionOf(tok rd.Token) Position {
+	if v, ok := tok.(Token); ok {
+		return v.Pos
+	}
+	return Position{}
+}
@@ -0,0 +1,162 @@
+package rdparser
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type EventKind int
+
+const (
+	EventEnter EventKind = iota
+	EventExit
+	EventMatch
+	EventBacktrack
+	EventRecall
+	EventError
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventEnter:
+		return "enter"
+	case EventExit:
+		return "exit"
+	case EventMatch:
+		return "match"
+	case EventBacktrack:
+		return "backtrack"
+	case EventRecall:
+		return "recall"
+	case EventError:
+		return "error"
+	}
+	return fmt.Sprintf("EventKind(%d)", int(k))
+}
+
+// Event is a step taken by the Builder. Symbol is the non-terminal being
+// entered, exited, backtracked or recalled, or the terminal a match was tried
+// for. Pos is the position of the next token when the event happened, Text the
+// matched token text and OK the result of exits, matches and recalls.
+type Event struct {
+	Kind   EventKind
+	Symbol fmt.Stringer
+	Depth  int
+	Pos    Position
+	Text   string
+	OK     bool
+	Msg    string
+}
+
+type Tracer interface {
+	Event(e Event)
+}
+
+type TracerFunc func(e Event)
+
+func (fn TracerFunc) Event(e Event) {
+	fn(e)
+}
+
+func WithTracer(t Tracer) Option {
+	return func(o *options) {
+		o.tracer = t
+	}
+}
+
+func (b *Builder) emit(e Event) {
+	if b.coverage != nil {
+		b.coverage.event(e)
+	}
+	if b.tracer == nil {
+		return
+	}
+	if !e.Pos.IsValid() {
+		e.Pos = b.pos()
+	}
+	b.tracer.Event(e)
+}
+
+// depth is the nesting of the non-terminal entered last, not counting the
+// root Compile enters.
+func (b *Builder) depth() int {
+	return len(b.stack) - 2
+}
+
+type textTracer struct {
+	w io.Writer
+}
+
+// NewTextTracer writes one indented line per event:
+//
+//	enter Factor at 1:1
+//	  match "(" at 1:1 ok
+//	  enter Factor' at 1:2
+func NewTextTracer(w io.Writer) Tracer {
+	return &textTracer{w: w}
+}
+
+func (t *textTracer) Event(e Event) {
+	indent := strings.Repeat("  ", max(e.Depth, 0))
+
+	var detail string
+	switch e.Kind {
+	case EventMatch:
+		detail = fmt.Sprintf("%q at %s", e.Symbol.String(), e.Pos)
+		if e.OK {
+			detail += " ok"
+		} else {
+			detail += " failed"
+		}
+	case EventExit, EventRecall:
+		detail = e.Symbol.String()
+		if e.OK {
+			detail += fmt.Sprintf(" ok, next at %s", e.Pos)
+		} else {
+			detail += " failed"
+		}
+	case EventError:
+		detail = fmt.Sprintf("in %s: %s at %s", e.Symbol, e.Msg, e.Pos)
+	default:
+		detail = fmt.Sprintf("%s at %s", e.Symbol, e.Pos)
+	}
+
+	fmt.Fprintf(t.w, "%s%s %s\n", indent, e.Kind, detail)
+}
+
+type jsonTracer struct {
+	enc *json.Encoder
+}
+
+type eventJSON struct {
+	Event  string   `json:"event"`
+	Symbol string   `json:"symbol"`
+	Depth  int      `json:"depth"`
+	Pos    Position `json:"pos"`
+	Text   string   `json:"text,omitempty"`
+	OK     *bool    `json:"ok,omitempty"`
+	Msg    string   `json:"msg,omitempty"`
+}
+
+// NewJSONTracer writes one JSON object per event and line.
+func NewJSONTracer(w io.Writer) Tracer {
+	return &jsonTracer{enc: json.NewEncoder(w)}
+}
+
+func (t *jsonTracer) Event(e Event) {
+	v := eventJSON{
+		Event:  e.Kind.String(),
+		Symbol: e.Symbol.String(),
+		Depth:  e.Depth,
+		Pos:    e.Pos,
+		Text:   e.Text,
+		Msg:    e.Msg,
+	}
+	if e.Kind == EventExit || e.Kind == EventMatch || e.Kind == EventRecall {
+		ok := e.OK
+		v.OK = &ok
+	}
+	t.enc.Encode(v)
+}
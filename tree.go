@@ -20,12 +20,22 @@ func (t *Tree) Has(n int) bool {
 }
 
 func (t *Tree) AsTerminal() Terminal {
-	if sym, ok := t.Symbol.(Terminal); ok {
+	if sym, ok := TerminalOf(t.Symbol); ok {
 		return sym
 	}
 	panic(NewRuntimeError("not a terminal symbol"))
 }
 
+func (t *Tree) AsToken() Token {
+	switch v := t.Symbol.(type) {
+	case Token:
+		return v
+	case Terminal:
+		return Token{Terminal: v, Text: v.String()}
+	}
+	panic(NewRuntimeError("not a terminal symbol"))
+}
+
 func (t *Tree) AsNonTerminal() NonTerminal {
 	if sym, ok := t.Symbol.(NonTerminal); ok {
 		return sym
@@ -37,6 +47,19 @@ func (t *Tree) At(index int) *Tree {
 	return &Tree{Tree: t.Subtrees[index]}
 }
 
+func (t *Tree) Pos() Position {
+	if t.IsTerminal() {
+		return PositionOf(t.Symbol)
+	}
+	for _, sub := range t.Subtrees {
+		subt := &Tree{Tree: sub}
+		if pos := subt.Pos(); pos.IsValid() {
+			return pos
+		}
+	}
+	return Position{}
+}
+
 func (t *Tree) IsTerminal() bool {
 	return IsTerminal(t.Symbol)
 }
@@ -86,7 +109,7 @@ func (t *Tree) Traverse(sep string) string {
 }
 
 func (t *Tree) Walk() []string {
-	if t.Len() == 0 {
+	if t.IsTerminal() {
 		return []string{string(t.AsTerminal())}
 	}
 
@@ -98,3 +121,30 @@ func (t *Tree) Walk() []string {
 
 	return children
 }
+
+// addEOF appends the EOF token, if any, as the last leaf of t.
+func (t *Tree) addEOF(eof rd.Token) {
+	if t != nil && eof != nil {
+		t.Add(rd.NewTree(eof))
+	}
+}
+
+// Source returns the text of the tokens under t along with their trivia. It
+// only reproduces the input for trees compiled without WithAnnotations: the
+// tokens that Hidden and List leave out of the tree take their text and
+// trivia with them.
+func (t *Tree) Source() string {
+	sb := &strings.Builder{}
+	NewVisitor().Leaf(func(t *Tree) error {
+		tok := t.AsToken()
+		sb.WriteString(tok.Leading)
+		sb.WriteString(tok.Text)
+		sb.WriteString(tok.Trailing)
+		return nil
+	}).Walk(t)
+	return sb.String()
+}
+
+func (t *Tree) IsError() bool {
+	return IsNonTerminalOf(t.Symbol, ErrorSymbol)
+}
@@ -0,0 +1,142 @@
+package rdparser
+
+import (
+	"errors"
+
+	"github.com/shivamMg/rd"
+)
+
+var (
+	// SkipChildren returned from a pre-order callback skips the subtree of the
+	// node, including its post-order callback.
+	SkipChildren = errors.New("skip children")
+
+	// SkipAll returned from any callback ends the walk without an error.
+	SkipAll = errors.New("skip all")
+)
+
+type VisitFunc func(t *Tree) error
+
+// Visitor walks a tree depth-first, calling the pre-order and post-order
+// callbacks registered for each non-terminal and the leaf callback for each
+// terminal.
+type Visitor struct {
+	pre  map[NonTerminal]VisitFunc
+	post map[NonTerminal]VisitFunc
+	leaf VisitFunc
+}
+
+func NewVisitor() *Visitor {
+	return &Visitor{
+		pre:  make(map[NonTerminal]VisitFunc),
+		post: make(map[NonTerminal]VisitFunc),
+	}
+}
+
+func (v *Visitor) Pre(sym NonTerminal, fn VisitFunc) *Visitor {
+	v.pre[sym] = fn
+	return v
+}
+
+func (v *Visitor) Post(sym NonTerminal, fn VisitFunc) *Visitor {
+	v.post[sym] = fn
+	return v
+}
+
+func (v *Visitor) Leaf(fn VisitFunc) *Visitor {
+	v.leaf = fn
+	return v
+}
+
+func (v *Visitor) Walk(t *Tree) error {
+	if err := v.walk(t); err != nil && err != SkipAll {
+		return err
+	}
+	return nil
+}
+
+func (v *Visitor) walk(t *Tree) error {
+	if t.IsTerminal() {
+		if v.leaf != nil {
+			return v.leaf(t)
+		}
+		return nil
+	}
+
+	sym := t.AsNonTerminal()
+	if fn, ok := v.pre[sym]; ok {
+		if err := fn(t); err == SkipChildren {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < t.Len(); i++ {
+		if err := v.walk(t.At(i)); err != nil {
+			return err
+		}
+	}
+
+	if fn, ok := v.post[sym]; ok {
+		return fn(t)
+	}
+	return nil
+}
+
+type RewriteFunc func(t *Tree) (*Tree, error)
+
+// Rewriter builds a modified copy of a tree bottom-up. Each callback receives
+// a copy of its node whose children have already been rewritten and returns
+// the replacement, or nil to drop the node. The input tree is not modified.
+type Rewriter struct {
+	rules map[NonTerminal]RewriteFunc
+	leaf  RewriteFunc
+}
+
+func NewRewriter() *Rewriter {
+	return &Rewriter{
+		rules: make(map[NonTerminal]RewriteFunc),
+	}
+}
+
+func (r *Rewriter) Rule(sym NonTerminal, fn RewriteFunc) *Rewriter {
+	r.rules[sym] = fn
+	return r
+}
+
+func (r *Rewriter) Leaf(fn RewriteFunc) *Rewriter {
+	r.leaf = fn
+	return r
+}
+
+func (r *Rewriter) Rewrite(t *Tree) (*Tree, error) {
+	if t.IsTerminal() {
+		leaf := &Tree{Tree: rd.NewTree(t.Symbol)}
+		if r.leaf != nil {
+			return r.leaf(leaf)
+		}
+		return leaf, nil
+	}
+
+	node := &Tree{Tree: rd.NewTree(t.Symbol)}
+	for i := 0; i < t.Len(); i++ {
+		sub, err := r.Rewrite(t.At(i))
+		if err != nil {
+			return nil, err
+		}
+		if sub != nil {
+			node.Add(sub.Tree)
+		}
+	}
+
+	if fn, ok := r.rules[t.AsNonTerminal()]; ok {
+		return fn(node)
+	}
+	return node, nil
+}
+
+func (t *Tree) Clone() *Tree {
+	clone, _ := NewRewriter().Rewrite(t)
+	return clone
+}